@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+)
+
+// runChainShow prints the chain tip, or the block given by height or hash
+func runChainShow(cmd *command, args []string) error {
+	cfg, args, err := loadConfig(cmd.flagSet(), args, 1)
+	if err != nil {
+		return err
+	}
+	client, err := apiClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		block, err := client.Block(args[0])
+		if err != nil {
+			return err
+		}
+		return printJSON(block)
+	}
+	info, err := client.ChainInfo()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Height:        %d\n", info.Height)
+	fmt.Printf("Tip:           %s\n", info.TipHash)
+	fmt.Printf("Difficulty:    %d\n", info.Difficulty)
+	fmt.Printf("Pruned height: %d\n", info.PrunedHeight)
+	fmt.Printf("Pending:       %d transactions\n", info.Pending)
+	return nil
+}
+
+// runChainVerify validates the stored chain and fails if it was invalid. The
+// chain is left untouched unless --repair is given.
+func runChainVerify(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	levelName := fs.String("level", blockchain.DefaultValidationLevel.String(), "validation level: quick or full")
+	repair := fs.Bool("repair", false, "discard the blocks from the first invalid one onwards")
+	cfg, _, err := loadConfig(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	level, err := blockchain.ParseValidationLevel(*levelName)
+	if err != nil {
+		return usagef("--level: %v", err)
+	}
+
+	if !*repair {
+		report, err := blockchain.VerifyStoredChain(cfg.DataDir, level)
+		if err != nil {
+			return fmt.Errorf("failed to verify chain: %w", offline(err))
+		}
+		fmt.Println(report)
+		if !report.Valid() {
+			return fmt.Errorf("chain is invalid from height %d; run with --repair to discard the invalid blocks", report.FirstBad.Height)
+		}
+		return nil
+	}
+
+	bc, report, err := blockchain.OpenBlockchain(cfg.DataDir, level)
+	if err != nil {
+		return fmt.Errorf("failed to open chain: %w", offline(err))
+	}
+	defer bc.Close()
+
+	fmt.Println(report)
+	if !report.Valid() {
+		return fmt.Errorf("chain is invalid; blocks from height %d were discarded", report.FirstBad.Height)
+	}
+	return nil
+}
+
+// runChainExport writes the local chain to a portable archive file
+func runChainExport(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	outPath := fs.String("out", "", "archive file to write (required)")
+	cfg, _, err := loadConfig(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	if *outPath == "" {
+		return usagef("missing required option --out")
+	}
+
+	bc, err := blockchain.OpenStoredChain(cfg.DataDir, blockchain.ValidateFull)
+	if err != nil {
+		return fmt.Errorf("failed to open chain: %w", offline(err))
+	}
+	defer bc.Close()
+
+	file, err := os.Create(*outPath)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	if err := bc.ExportChain(file); err != nil {
+		file.Close()
+		os.Remove(*outPath)
+		return fmt.Errorf("failed to export chain: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync archive: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close archive: %v", err)
+	}
+	fmt.Printf("Exported %d blocks to %s\n", bc.Height()+1, *outPath)
+	return nil
+}
+
+// runChainImport validates and appends the blocks of an archive file to the
+// local chain
+func runChainImport(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	inPath := fs.String("in", "", "archive file to read (required)")
+	cfg, _, err := loadConfig(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	if *inPath == "" {
+		return usagef("missing required option --in")
+	}
+
+	file, err := os.Open(*inPath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %v", err)
+	}
+	defer file.Close()
+
+	bc, err := blockchain.NewBlockchain(cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open chain: %w", offline(err))
+	}
+	defer bc.Close()
+
+	imported, err := bc.ImportChain(file)
+	if err != nil {
+		return fmt.Errorf("import stopped after %d blocks: %v", imported, err)
+	}
+	fmt.Printf("Imported %d blocks, chain height is now %d\n", imported, bc.Height())
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/ipfs"
+)
+
+// runDatasetList prints the files in the IPFS dataset folder
+func runDatasetList(cmd *command, args []string) error {
+	cfg, _, err := loadConfig(cmd.flagSet(), args, 0)
+	if err != nil {
+		return err
+	}
+	if cfg.IPFS.DatasetsCID == "" {
+		return usagef("the dataset folder is not set; use --datasets-cid or ipfs.datasets_cid")
+	}
+	files, err := ipfs.NewIPFSClient(cfg.IPFS.Gateway).ListFolder(cfg.IPFS.DatasetsCID)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCID\tSIZE")
+	for _, file := range files {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", file.Name, file.CID, file.Size)
+	}
+	return w.Flush()
+}
@@ -1,113 +1,283 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
-	"github.com/Wraitheon/blockchain-assignment/pkg/networking"
-	"github.com/Wraitheon/blockchain-assignment/pkg/node"
+	"github.com/Wraitheon/blockchain-assignment/pkg/api"
+	"github.com/Wraitheon/blockchain-assignment/pkg/config"
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
 )
 
-func main() {
-	// Parse command-line arguments
-	args := os.Args[1:]
-	if len(args) < 2 {
-		log.Fatal("Usage: go run main.go --port=<port> --address=<address> [--connect=<peer_address>]")
-	}
+// program is how the usage text refers to this binary
+const program = "blockchain"
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1 // the command failed
+	exitUsage = 2 // the command line was invalid
+)
+
+// command is one subcommand, run as "<group> <name>"
+type command struct {
+	group   string
+	name    string
+	args    string // synopsis of the positional arguments
+	summary string
+	help    string // shown by -h below the synopsis
+	run     func(cmd *command, args []string) error
+}
 
-	port := extractArg(args, "--port")
-	address := extractArg(args, "--address")
-	fullAddress := fmt.Sprintf("%s:%s", address, port)
+func (c *command) String() string {
+	return c.group + " " + c.name
+}
+
+var commands = []*command{
+	{group: "node", name: "run", summary: "run the node until interrupted", run: runNode,
+		help: "Runs the node: syncs the chain, relays transactions and blocks, and serves\n" +
+			"the API and mining if configured. SIGINT or SIGTERM stops it gracefully."},
+	{group: "chain", name: "show", args: "[<height>|<hash>]", summary: "show the chain tip or a block", run: runChainShow,
+		help: "Without an argument, prints the height, tip and difficulty of the running\n" +
+			"node's chain. With a height or hash, prints that block as JSON."},
+	{group: "chain", name: "verify", summary: "validate the stored chain", run: runChainVerify,
+		help: "Validates the chain in the data directory without changing it. With\n" +
+			"--repair, blocks from the first invalid one onwards are discarded, as at\n" +
+			"node startup. Exits with 1 if the chain was invalid. The node must not be\n" +
+			"running."},
+	{group: "chain", name: "export", summary: "write the chain to an archive", run: runChainExport,
+		help: "Writes the stored chain to a portable archive file. The data directory\n" +
+			"is only read, and an invalid chain is refused; repair it with chain verify\n" +
+			"--repair first. The node must not be running."},
+	{group: "chain", name: "import", summary: "append an archive to the chain", run: runChainImport,
+		help: "Validates the blocks of an archive file and appends those that extend the\n" +
+			"stored chain. The node must not be running."},
+	{group: "snapshot", name: "export", summary: "write a state snapshot with the chain headers", run: runSnapshotExport,
+		help: "Takes a state snapshot at the tip of the stored chain and writes it with\n" +
+			"the chain's headers to a file, and prints the checkpoint that pins it.\n" +
+			"The data directory is only read, and an invalid chain is refused. The\n" +
+			"node must not be running."},
+	{group: "snapshot", name: "import", summary: "start the chain from a state snapshot", run: runSnapshotImport,
+		help: "Starts a new chain from a snapshot file: the headers are validated and\n" +
+			"kept without bodies, and the node syncs onward from the snapshot. On an\n" +
+			"existing chain the snapshot must match one of its blocks. A checkpoint in\n" +
+			"the --checkpoint-file list must pin the snapshot's block and state hash.\n" +
+			"The node must not be running."},
+	{group: "tx", name: "submit", summary: "submit a transaction", run: runTxSubmit,
+		help: "Submits a JSON transaction to the running node, which relays it to peers.\n" +
+			"With --sign the transaction is signed with the node key first."},
+	{group: "tx", name: "get", args: "<txid>", summary: "show a pending or confirmed transaction", run: runTxGet,
+		help: "Prints the transaction with the given ID and where it was confirmed."},
+	{group: "mine", name: "once", summary: "mine one block", run: runMineOnce,
+		help: "Mines a block from the running node's pending transactions and submits it."},
+	{group: "wallet", name: "show", summary: "show the node address and balance", run: runWalletShow,
+		help: "Prints the address of the node key, creating the key if needed, and its\n" +
+			"balance and nonces if the node's API is reachable."},
+	{group: "peers", name: "list", summary: "list connected peers", run: runPeersList,
+		help: "Lists the peers the running node is connected to."},
+	{group: "peers", name: "add", args: "[<id>@]<host:port>", summary: "connect to a peer and keep it", run: runPeersAdd,
+		help: "Makes the running node connect to a peer and remember it as persistent,\n" +
+			"so it is reconnected after failures and restarts."},
+	{group: "dataset", name: "list", summary: "list the datasets on IPFS", run: runDatasetList,
+		help: "Lists the files in the dataset folder on IPFS."},
+	{group: "config", name: "dump", summary: "print the effective configuration", run: runConfigDump,
+		help: "Prints the configuration that results from the config file, environment\nand flags."},
+}
 
-	// Initialize the PeerManager
-	peerManager := networking.NewPeerManager()
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
 
-	// Start the networking server
-	err := networking.StartServer(fullAddress, peerManager, func(message networking.Message) {
-		fmt.Printf("Received message: %s - %s\n", message.Type, message.Payload)
-		// TODO: Handle received transactions and broadcast to peers if necessary
-	})
+// run dispatches args to a command and returns the exit code
+func run(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	if isHelp(args[0]) {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	cmd, err := findCommand(args)
 	if err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	err = cmd.run(cmd, args[2:])
+	var usage *usageError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usage):
+		if !usage.shown {
+			fmt.Fprintf(os.Stderr, "Error: %v\nRun '%s %s -h' for usage.\n", err, program, cmd)
+		}
+		return exitUsage
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitError
 	}
+}
 
-	// Handle optional peer connection
-	if peerAddr := extractOptionalArg(args, "--connect"); peerAddr != "" {
-		conn, err := networking.ConnectToPeer(peerAddr)
-		if err != nil {
-			log.Fatalf("Failed to connect to peer: %v", err)
+// findCommand returns the command named by the first two arguments
+func findCommand(args []string) (*command, error) {
+	var inGroup []string
+	for _, cmd := range commands {
+		if cmd.group != args[0] {
+			continue
+		}
+		if len(args) > 1 && cmd.name == args[1] {
+			return cmd, nil
 		}
-		defer conn.Close()
-		peerManager.AddPeer(peerAddr, conn)
-		fmt.Printf("Connected to peer at %s\n", peerAddr)
+		inGroup = append(inGroup, cmd.name)
+	}
+	switch {
+	case len(inGroup) == 0:
+		return nil, fmt.Errorf("unknown command %q", args[0])
+	case len(args) < 2:
+		return nil, fmt.Errorf("%s needs a subcommand: %s", args[0], strings.Join(inGroup, ", "))
+	default:
+		return nil, fmt.Errorf("unknown command %q, %s has: %s", args[0]+" "+args[1], args[0], strings.Join(inGroup, ", "))
+	}
+}
+
+func isHelp(arg string) bool {
+	return arg == "help" || arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// printUsage lists the commands
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s <command> <subcommand> [options] [arguments]\n\nCommands:\n", program)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-36s %s\n", strings.TrimSpace(cmd.String()+" "+cmd.args), cmd.summary)
 	}
+	fmt.Fprintf(w, `
+Options are read from the --config file, then $%s* environment variables,
+then flags; run '%s <command> <subcommand> -h' to list them.
 
-	// IPFS-related setup
-	ipfsGateway := "http://localhost:5001"
-	tempDir := filepath.Join(".", "temp") // Using the root directory for temp files
+Exit status is %d on success, %d if the command failed and %d if the command
+line was invalid.
+`, config.EnvPrefix, program, exitOK, exitError, exitUsage)
+}
+
+// usageError is an invalid command line. shown is set when the flag package
+// has already reported it.
+type usageError struct {
+	err   error
+	shown bool
+}
 
-	// CID constants for the IPFS files
-	const configCID = "QmPagXcseqBzKDFL2F4oEDYcuAkiixy28ZF3N3yfLSPUjJ"
-	const algorithmCID = "QmQVpkvKaRPq8hzqG3NThTzKSsFBkkJW6FkJkHfu48ncMf"
-	const folderCID = "QmZSWXRErHNeYFo7dt5LR28o8NgnWmXndfbmxr9R2bLN7W"
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+// usagef returns a usageError with a formatted message
+func usagef(format string, args ...interface{}) error {
+	return &usageError{err: fmt.Errorf(format, args...)}
+}
 
-	// Initialize the blockchain node
-	blockchainNode := node.NewNode(ipfsGateway, tempDir)
+// flagSet creates the flag set of cmd, with help text built from its fields
+func (c *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.String(), flag.ContinueOnError)
+	fs.Usage = func() {
+		synopsis := strings.TrimSpace(fmt.Sprintf("%s %s [options] %s", program, c, c.args))
+		fmt.Fprintf(fs.Output(), "Usage: %s\n\n%s\n\nOptions:\n", synopsis, c.help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
 
-	// Download required files from IPFS and create a transaction
-	transaction, err := blockchainNode.DownloadRequiredFiles(configCID, algorithmCID, folderCID)
+// loadConfig registers the configuration flags on fs, parses args and loads
+// the configuration. At most maxArgs positional arguments are accepted and
+// returned.
+func loadConfig(fs *flag.FlagSet, args []string, maxArgs int) (config.Config, []string, error) {
+	flags := config.RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return config.Config{}, nil, err
+		}
+		return config.Config{}, nil, &usageError{err: err, shown: true}
+	}
+	if fs.NArg() > maxArgs {
+		return config.Config{}, nil, usagef("unexpected argument %q", fs.Arg(maxArgs))
+	}
+	cfg, err := flags.Load()
 	if err != nil {
-		log.Fatalf("Error downloading required files: %v", err)
+		return config.Config{}, nil, &usageError{err: err}
 	}
+	return cfg, fs.Args(), nil
+}
 
-	// Log the created transaction
-	fmt.Println("Created Transaction:")
-	fmt.Println(transaction.Serialize())
+// apiClient returns a client for the API of the node configured by cfg
+func apiClient(cfg config.Config) (*api.Client, error) {
+	if cfg.API.Address == "" {
+		return nil, usagef("the node API address is not set; use --api or api.address")
+	}
+	return api.NewClient(cfg.API.Address), nil
+}
 
-	// Broadcast the transaction to peers
-	message := networking.Message{
-		Type:    "transaction",
-		Payload: transaction.Serialize(),
+// offline explains an error opening the data directory of a running node
+func offline(err error) error {
+	if errors.Is(err, storage.ErrDataDirInUse) {
+		return fmt.Errorf("%v; stop the node first", err)
 	}
-	peerManager.Broadcast(message)
+	return err
+}
 
-	// Periodically broadcast dummy transactions for testing
-	go func() {
-		for i := 0; i < 5; i++ {
-			testMessage := networking.Message{
-				Type:    "transaction",
-				Payload: fmt.Sprintf("Test Transaction #%d", i+1),
-			}
-			peerManager.Broadcast(testMessage)
-			time.Sleep(2 * time.Second)
-		}
-	}()
+// nodeKeyPath returns where the node's signing key is kept
+func nodeKeyPath(cfg config.Config) string {
+	return filepath.Join(cfg.DataDir, "node.key")
+}
 
-	// Keep the program running
-	select {}
+// printJSON writes value to stdout as indented JSON
+func printJSON(value interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
 }
 
-// extractArg retrieves a required argument from the command-line arguments.
-func extractArg(args []string, key string) string {
-	for _, arg := range args {
-		if strings.HasPrefix(arg, key+"=") {
-			return strings.SplitN(arg, "=", 2)[1]
-		}
+// runConfigDump prints the configuration that results from the config file,
+// environment and flags
+func runConfigDump(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	format := fs.String("format", "toml", "output format: toml, yaml or json")
+	cfg, _, err := loadConfig(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	if *format != "toml" && *format != "yaml" && *format != "json" {
+		return usagef("unknown format %q, use toml, yaml or json", *format)
 	}
-	log.Fatalf("Missing required argument: %s", key)
-	return ""
+	return cfg.Write(os.Stdout, *format)
 }
 
-// extractOptionalArg retrieves an optional argument from the command-line arguments.
-func extractOptionalArg(args []string, key string) string {
-	for _, arg := range args {
-		if strings.HasPrefix(arg, key+"=") {
-			return strings.SplitN(arg, "=", 2)[1]
-		}
+// setupLogging directs the standard logger as configured and returns a
+// function that closes the log file
+func setupLogging(cfg config.LogConfig) (func(), error) {
+	if cfg.Timestamps {
+		log.SetFlags(log.LstdFlags)
+	} else {
+		log.SetFlags(0)
+	}
+	if cfg.File == "" {
+		return func() {}, nil
+	}
+	file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %v", err)
 	}
-	return ""
+	log.SetOutput(file)
+	return func() {
+		log.SetOutput(os.Stderr)
+		file.Close()
+	}, nil
 }
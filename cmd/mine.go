@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// runMineOnce mines a block template from the running node and submits it
+func runMineOnce(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	recipient := fs.String("recipient", "", "address paid the block reward (default the node's own)")
+	empty := fs.Bool("empty", false, "mine even if no transactions are pending")
+	cfg, _, err := loadConfig(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	client, err := apiClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	template, err := client.BlockTemplate(*recipient)
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, tx := range template.Block.Transactions {
+		if !tx.IsCoinbase() {
+			pending++
+		}
+	}
+	if pending == 0 && !*empty {
+		return errors.New("no transactions to mine; use --empty to mine anyway")
+	}
+
+	// Solve proof-of-work
+	block := template.Block
+	block.MineBlock(template.Difficulty)
+	if err := client.SubmitBlock(block); err != nil {
+		return err
+	}
+	fmt.Printf("Mined block %d (%s) with %d transactions\n", block.Index, block.Hash, pending)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/networking"
+	"github.com/Wraitheon/blockchain-assignment/pkg/node"
+)
+
+// runNode runs the node until SIGINT or SIGTERM
+func runNode(cmd *command, args []string) error {
+	cfg, _, err := loadConfig(cmd.flagSet(), args, 0)
+	if err != nil {
+		return err
+	}
+	closeLog, err := setupLogging(cfg.Log)
+	if err != nil {
+		return err
+	}
+	defer closeLog()
+
+	// Initialize the blockchain node
+	blockchainNode, err := node.NewNodeWithConfig(cfg.Node())
+	if err != nil {
+		return fmt.Errorf("failed to open node: %w", offline(err))
+	}
+
+	// Run the node's services until SIGINT or SIGTERM; a second signal kills
+	// the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := blockchainNode.Start(ctx); err != nil {
+		blockchainNode.Stop()
+		return fmt.Errorf("failed to start node: %v", err)
+	}
+	id := blockchainNode.Network.ID()
+	fmt.Printf("Node ID: %s (pin with --connect=%s)\n", id, networking.JoinPeerAddress(id, cfg.ListenAddress()))
+
+	// Download required files from IPFS and create a transaction. This waits
+	// for a dataset choice on stdin, so it runs aside to keep shutdown responsive.
+	if cfg.IPFS.ConfigCID != "" && cfg.IPFS.AlgorithmCID != "" && cfg.IPFS.DatasetsCID != "" {
+		go func() {
+			transaction, err := blockchainNode.DownloadRequiredFiles(cfg.IPFS.ConfigCID, cfg.IPFS.AlgorithmCID, cfg.IPFS.DatasetsCID)
+			if err != nil {
+				log.Printf("Error downloading required files: %v", err)
+				return
+			}
+
+			// Log the created transaction
+			fmt.Println("Created Transaction:")
+			fmt.Println(transaction.Serialize())
+
+			// Submit the transaction; peers receive it through mempool gossip
+			if err := blockchainNode.Consensus.VerifyAndAddTransaction(transaction); err != nil {
+				log.Printf("Transaction was not accepted: %v", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	stop()
+	log.Println("Shutting down")
+	if err := blockchainNode.Stop(); err != nil {
+		return fmt.Errorf("error during shutdown: %v", err)
+	}
+	return nil
+}
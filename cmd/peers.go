@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/networking"
+)
+
+// runPeersList prints the running node's peers
+func runPeersList(cmd *command, args []string) error {
+	cfg, _, err := loadConfig(cmd.flagSet(), args, 0)
+	if err != nil {
+		return err
+	}
+	client, err := apiClient(cfg)
+	if err != nil {
+		return err
+	}
+	peers, err := client.Peers()
+	if err != nil {
+		return err
+	}
+	if len(peers) == 0 {
+		fmt.Println("No peers connected")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tADDRESS\tDIRECTION\tSCORE\tPING")
+	for _, peer := range peers {
+		direction := "inbound"
+		if peer.Outbound {
+			direction = "outbound"
+		}
+		ping := "-"
+		if peer.PingTime > 0 {
+			ping = peer.PingTime.Round(time.Microsecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", peer.ID, peer.Address, direction, peer.Score, ping)
+	}
+	return w.Flush()
+}
+
+// runPeersAdd makes the running node connect to a peer and keep it
+func runPeersAdd(cmd *command, args []string) error {
+	cfg, args, err := loadConfig(cmd.flagSet(), args, 1)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usagef("missing peer address")
+	}
+	if err := networking.ValidAddress(args[0]); err != nil {
+		return usagef("%v", err)
+	}
+	client, err := apiClient(cfg)
+	if err != nil {
+		return err
+	}
+	peer, err := client.AddPeer(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Connected to %s at %s\n", peer.ID, peer.Address)
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+)
+
+// runSnapshotExport writes a state snapshot at the tip, with the chain's
+// headers, to a file
+func runSnapshotExport(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	outPath := fs.String("out", "", "snapshot file to write (required)")
+	cfg, _, err := loadConfig(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	if *outPath == "" {
+		return usagef("missing required option --out")
+	}
+
+	bc, err := blockchain.OpenStoredChain(cfg.DataDir, blockchain.ValidateFull)
+	if err != nil {
+		return fmt.Errorf("failed to open chain: %w", offline(err))
+	}
+	defer bc.Close()
+
+	bundle, err := bc.ExportSnapshot()
+	if err != nil {
+		return fmt.Errorf("failed to take snapshot: %v", err)
+	}
+	file, err := os.Create(*outPath)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %v", err)
+	}
+	if err := json.NewEncoder(file).Encode(bundle); err != nil {
+		file.Close()
+		os.Remove(*outPath)
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close snapshot file: %v", err)
+	}
+	fmt.Printf("Exported the snapshot at height %d to %s\n", bundle.Snapshot.Height, *outPath)
+	fmt.Printf("To let nodes import it, sign a checkpoint with height %d, hash %s and state_hash %s\n",
+		bundle.Snapshot.Height, bundle.Snapshot.BlockHash, bundle.Snapshot.StateHash)
+	return nil
+}
+
+// runSnapshotImport starts a new chain from a snapshot file, or installs the
+// snapshot on a chain that already has its block. The snapshot must match a
+// checkpoint in the configured signed list.
+func runSnapshotImport(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	inPath := fs.String("in", "", "snapshot file to read (required)")
+	cfg, _, err := loadConfig(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	if *inPath == "" {
+		return usagef("missing required option --in")
+	}
+	if cfg.Chain.CheckpointFile == "" {
+		return usagef("a snapshot must be pinned by a signed checkpoint; use --checkpoint-file and --checkpoint-pubkey")
+	}
+	checkpoints, err := blockchain.LoadCheckpoints(cfg.Chain.CheckpointFile, cfg.Chain.CheckpointPubKey)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(*inPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot file: %v", err)
+	}
+	var bundle blockchain.SnapshotBundle
+	err = json.NewDecoder(file).Decode(&bundle)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %v", err)
+	}
+
+	bc, err := blockchain.NewBlockchain(cfg.DataDir)
+	if err != nil {
+		return fmt.Errorf("failed to open chain: %w", offline(err))
+	}
+	defer bc.Close()
+	if err := bc.SetCheckpoints(checkpoints); err != nil {
+		return err
+	}
+
+	if bc.Height() == 0 {
+		err = bc.StartFromSnapshot(bundle)
+	} else {
+		err = bc.ImportSnapshot(bundle.Snapshot)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to import snapshot: %v", err)
+	}
+	fmt.Printf("Imported the snapshot at height %d, chain height is now %d\n", bundle.Snapshot.Height, bc.Height())
+	return nil
+}
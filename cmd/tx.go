@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+)
+
+// runTxSubmit submits a JSON transaction read from a file or stdin
+func runTxSubmit(cmd *command, args []string) error {
+	fs := cmd.flagSet()
+	path := fs.String("file", "-", "JSON transaction to submit; - reads stdin")
+	sign := fs.Bool("sign", false, "sign with the node key and its next pending nonce")
+	cfg, _, err := loadConfig(fs, args, 0)
+	if err != nil {
+		return err
+	}
+	client, err := apiClient(cfg)
+	if err != nil {
+		return err
+	}
+
+	var input io.Reader = os.Stdin
+	if *path != "-" {
+		file, err := os.Open(*path)
+		if err != nil {
+			return fmt.Errorf("failed to open transaction: %v", err)
+		}
+		defer file.Close()
+		input = file
+	}
+	var tx blockchain.Transaction
+	decoder := json.NewDecoder(input)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&tx); err != nil {
+		return fmt.Errorf("invalid transaction: %v", err)
+	}
+
+	if *sign {
+		key, err := blockchain.LoadOrCreateKey(nodeKeyPath(cfg))
+		if err != nil {
+			return err
+		}
+		account, err := client.Account(blockchain.AddressFromKey(key))
+		if err != nil {
+			return err
+		}
+		tx.Sign(key, account.PendingNonce)
+	}
+
+	txid, err := client.SubmitTransaction(tx)
+	if err != nil {
+		return err
+	}
+	fmt.Println(txid)
+	return nil
+}
+
+// runTxGet prints a pending or confirmed transaction
+func runTxGet(cmd *command, args []string) error {
+	cfg, args, err := loadConfig(cmd.flagSet(), args, 1)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return usagef("missing transaction ID")
+	}
+	client, err := apiClient(cfg)
+	if err != nil {
+		return err
+	}
+	status, err := client.Transaction(args[0])
+	if err != nil {
+		return err
+	}
+	return printJSON(status)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+)
+
+// runWalletShow prints the node key's address and, if the node is running,
+// its balance and nonces
+func runWalletShow(cmd *command, args []string) error {
+	cfg, _, err := loadConfig(cmd.flagSet(), args, 0)
+	if err != nil {
+		return err
+	}
+	key, err := blockchain.LoadOrCreateKey(nodeKeyPath(cfg))
+	if err != nil {
+		return err
+	}
+	address := blockchain.AddressFromKey(key)
+	fmt.Printf("Address:       %s\n", address)
+	if cfg.API.Address == "" {
+		return nil
+	}
+
+	client, err := apiClient(cfg)
+	if err != nil {
+		return err
+	}
+	state, err := client.Account(address)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Balance:       %d\n", state.Balance)
+	fmt.Printf("Next nonce:    %d\n", state.NextNonce)
+	fmt.Printf("Pending nonce: %d\n", state.PendingNonce)
+	return nil
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+	"github.com/Wraitheon/blockchain-assignment/pkg/consensus"
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+// ChainInfo summarizes the node's chain
+type ChainInfo struct {
+	Height       int    `json:"height"`
+	TipHash      string `json:"tip_hash"`
+	Difficulty   int    `json:"difficulty"`
+	PrunedHeight int    `json:"pruned_height"`
+	Pending      int    `json:"pending"` // transactions in the mempool
+}
+
+// TxStatus is a transaction and whether it is pending or confirmed. The
+// block fields are set once it is confirmed.
+type TxStatus struct {
+	TxID        string                 `json:"txid"`
+	Status      string                 `json:"status"` // pending or confirmed
+	Transaction blockchain.Transaction `json:"transaction"`
+	BlockHeight int                    `json:"block_height,omitempty"`
+	BlockHash   string                 `json:"block_hash,omitempty"`
+	Position    int                    `json:"position,omitempty"`
+	Pruned      bool                   `json:"pruned,omitempty"` // the block body is gone; only the location is known
+}
+
+// Account is the confirmed and pending state of an address
+type Account struct {
+	Address      string `json:"address"`
+	Balance      uint64 `json:"balance"`
+	NextNonce    uint64 `json:"next_nonce"`    // according to the chain
+	PendingNonce uint64 `json:"pending_nonce"` // counting pending transactions
+}
+
+// handleChain reports the chain tip
+func (s *Server) handleChain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	height := s.Blockchain.Height()
+	tip, _ := s.Blockchain.GetBlockByHeight(height)
+	writeJSON(w, http.StatusOK, ChainInfo{
+		Height:       height,
+		TipHash:      tip.Hash,
+		Difficulty:   s.Blockchain.Difficulty(),
+		PrunedHeight: s.Blockchain.PrunedHeight(),
+		Pending:      s.Mempool.Len(),
+	})
+}
+
+// handleBlock returns the block with the height or hash in the path
+func (s *Server) handleBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.PathValue("id")
+	var block blockchain.Block
+	var found bool
+	if height, err := strconv.Atoi(id); err == nil {
+		block, found = s.Blockchain.GetBlockByHeight(height)
+	} else {
+		block, found = s.Blockchain.GetBlockByHash(id)
+	}
+	if !found {
+		http.Error(w, fmt.Sprintf("block %s not found", id), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, block)
+}
+
+// handleSubmitTransaction admits a signed transaction to the mempool, which
+// announces it to peers
+func (s *Server) handleSubmitTransaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var tx blockchain.Transaction
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, consensus.DefaultMaxBlockSize)).Decode(&tx); err != nil {
+		http.Error(w, fmt.Sprintf("invalid transaction: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := s.Consensus.VerifyAndAddTransaction(tx); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"txid": tx.ID()})
+}
+
+// handleTransaction returns the pending or confirmed transaction with the ID
+// in the path
+func (s *Server) handleTransaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := r.PathValue("id")
+	if pending, exists := s.Mempool.Get(id); exists {
+		if tx, ok := pending.(blockchain.Transaction); ok {
+			writeJSON(w, http.StatusOK, TxStatus{TxID: id, Status: "pending", Transaction: tx})
+			return
+		}
+	}
+	result, err := s.Blockchain.GetTransaction(id)
+	if errors.Is(err, storage.ErrTxNotFound) {
+		http.Error(w, fmt.Sprintf("transaction %s not found", id), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, TxStatus{
+		TxID:        result.TxID,
+		Status:      "confirmed",
+		Transaction: result.Transaction,
+		BlockHeight: result.BlockHeight,
+		BlockHash:   result.BlockHash,
+		Position:    result.Position,
+		Pruned:      result.Pruned,
+	})
+}
+
+// handleAccount returns the balance and nonces of the address in the path
+func (s *Server) handleAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	address := r.PathValue("address")
+	confirmed := s.Blockchain.NextNonce(address)
+	writeJSON(w, http.StatusOK, Account{
+		Address:      address,
+		Balance:      s.Blockchain.Balance(address),
+		NextNonce:    confirmed,
+		PendingNonce: s.Mempool.NextNonce(address, confirmed),
+	})
+}
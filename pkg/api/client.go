@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+	"github.com/Wraitheon/blockchain-assignment/pkg/consensus"
+	"github.com/Wraitheon/blockchain-assignment/pkg/networking"
+)
+
+// ClientTimeout bounds each request made by a Client
+const ClientTimeout = 30 * time.Second
+
+// StatusError is returned by a Client when the server answers with an error
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+// Client calls the API of a running node
+type Client struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+// NewClient creates a client for the API served on address. An unspecified
+// host such as 0.0.0.0 is reached through the loopback interface.
+func NewClient(address string) *Client {
+	host, port, err := net.SplitHostPort(address)
+	if err == nil {
+		if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+			host = "127.0.0.1"
+		}
+		address = net.JoinHostPort(host, port)
+	}
+	return &Client{
+		BaseURL: "http://" + address,
+		HTTP:    &http.Client{Timeout: ClientTimeout},
+	}
+}
+
+// ChainInfo returns the node's chain tip
+func (c *Client) ChainInfo() (ChainInfo, error) {
+	var info ChainInfo
+	err := c.do(http.MethodGet, "/chain", nil, &info)
+	return info, err
+}
+
+// Block returns the block with the given height or hash
+func (c *Client) Block(id string) (blockchain.Block, error) {
+	var block blockchain.Block
+	err := c.do(http.MethodGet, "/chain/blocks/"+url.PathEscape(id), nil, &block)
+	return block, err
+}
+
+// SubmitTransaction submits a signed transaction and returns its ID
+func (c *Client) SubmitTransaction(tx blockchain.Transaction) (string, error) {
+	var result struct {
+		TxID string `json:"txid"`
+	}
+	err := c.do(http.MethodPost, "/tx", tx, &result)
+	return result.TxID, err
+}
+
+// Transaction returns a pending or confirmed transaction
+func (c *Client) Transaction(id string) (TxStatus, error) {
+	var status TxStatus
+	err := c.do(http.MethodGet, "/tx/"+url.PathEscape(id), nil, &status)
+	return status, err
+}
+
+// Account returns the balance and nonces of an address
+func (c *Client) Account(address string) (Account, error) {
+	var account Account
+	err := c.do(http.MethodGet, "/accounts/"+url.PathEscape(address), nil, &account)
+	return account, err
+}
+
+// BlockTemplate returns a template paying address, or the node's own
+// address if it is empty
+func (c *Client) BlockTemplate(address string) (consensus.BlockTemplate, error) {
+	path := "/mining/template"
+	if address != "" {
+		path += "?address=" + url.QueryEscape(address)
+	}
+	var template consensus.BlockTemplate
+	err := c.do(http.MethodGet, path, nil, &template)
+	return template, err
+}
+
+// SubmitBlock submits a block mined from a template
+func (c *Client) SubmitBlock(block blockchain.Block) error {
+	return c.do(http.MethodPost, "/mining/submit", block, nil)
+}
+
+// Peers lists the node's connected peers
+func (c *Client) Peers() ([]networking.PeerInfo, error) {
+	var peers []networking.PeerInfo
+	err := c.do(http.MethodGet, "/peers", nil, &peers)
+	return peers, err
+}
+
+// AddPeer makes the node connect to address and keep it as a persistent peer
+func (c *Client) AddPeer(address string) (networking.PeerInfo, error) {
+	var info networking.PeerInfo
+	err := c.do(http.MethodPost, "/peers", addPeerRequest{Address: address}, &info)
+	return info, err
+}
+
+// do sends body as JSON and decodes the response into result, which may be nil
+func (c *Client) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	request, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	response, err := c.HTTP.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to reach node: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 4<<10))
+		return &StatusError{StatusCode: response.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(response.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/networking"
+)
+
+// addPeerRequest is the body of POST /peers
+type addPeerRequest struct {
+	Address string `json:"address"`
+}
+
+// handlePeers lists the connected peers on GET and connects to a new peer
+// on POST
+func (s *Server) handlePeers(w http.ResponseWriter, r *http.Request) {
+	if s.Network == nil {
+		http.Error(w, "networking is not running", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		peers := s.Network.PeerInfos()
+		sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+		writeJSON(w, http.StatusOK, peers)
+	case http.MethodPost:
+		s.handleAddPeer(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAddPeer remembers an address as a persistent peer and connects to
+// it. The address is kept even if connecting fails, so discovery retries it.
+func (s *Server) handleAddPeer(w http.ResponseWriter, r *http.Request) {
+	var request addPeerRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<10)).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := networking.ValidAddress(request.Address); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.Addresses != nil {
+		s.Addresses.AddPersistent(request.Address, "manual")
+		s.Addresses.Attempt(request.Address)
+	}
+	info, err := s.Network.ConnectPeer(request.Address)
+	switch {
+	case errors.Is(err, networking.ErrDuplicatePeer):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		if s.Addresses != nil {
+			s.Addresses.Failed(request.Address)
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if s.Addresses != nil {
+		s.Addresses.Good(request.Address)
+	}
+	writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+	"github.com/Wraitheon/blockchain-assignment/pkg/chainsync"
+	"github.com/Wraitheon/blockchain-assignment/pkg/consensus"
+	"github.com/Wraitheon/blockchain-assignment/pkg/networking"
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+// EventBuffer is the per-client buffer for streamed events. Clients that fall
+// further behind are disconnected.
+const EventBuffer = 256
+
+// Server exposes the node over HTTP
+type Server struct {
+	Consensus  *consensus.Consensus
+	Blockchain *blockchain.Blockchain
+	Mempool    *storage.Mempool
+	Syncer     *chainsync.Syncer          // optional; reports chain download progress
+	Network    *networking.PeerManager    // optional; lists and connects peers
+	Addresses  *networking.AddressManager // optional; remembers peers added through the API
+	mux        *http.ServeMux
+
+	mu     sync.Mutex
+	server *http.Server       // set by ListenAndServe
+	cancel context.CancelFunc // ends the requests of server, including streams
+	closed bool               // set by Shutdown
+}
+
+// NewServer creates an API server for the node's consensus engine
+func NewServer(cons *consensus.Consensus) *Server {
+	s := &Server{
+		Consensus:  cons,
+		Blockchain: cons.Blockchain,
+		Mempool:    cons.Mempool,
+		mux:        http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/mempool/events", s.handleMempoolEvents)
+	s.mux.HandleFunc("/mining/template", s.handleBlockTemplate)
+	s.mux.HandleFunc("/mining/submit", s.handleSubmitBlock)
+	s.mux.HandleFunc("/sync/progress", s.handleSyncProgress)
+	s.mux.HandleFunc("/chain", s.handleChain)
+	s.mux.HandleFunc("/chain/blocks/{id}", s.handleBlock)
+	s.mux.HandleFunc("/tx", s.handleSubmitTransaction)
+	s.mux.HandleFunc("/tx/{id}", s.handleTransaction)
+	s.mux.HandleFunc("/accounts/{address}", s.handleAccount)
+	s.mux.HandleFunc("/peers", s.handlePeers)
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe serves the API on address until the listener fails or
+// Shutdown is called, in which case it returns http.ErrServerClosed
+func (s *Server) ListenAndServe(address string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:        address,
+		Handler:     s,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		cancel()
+		return http.ErrServerClosed
+	}
+	s.server = server
+	s.cancel = cancel
+	s.mu.Unlock()
+	log.Printf("API server listening on %s", address)
+	return server.ListenAndServe()
+}
+
+// Shutdown stops the API server, ending event streams and waiting for other
+// requests in progress until ctx expires
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	server, cancel := s.server, s.cancel
+	s.mu.Unlock()
+	if server == nil {
+		return nil
+	}
+	cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		server.Close()
+	}
+	return err
+}
+
+// mempoolEvent is the JSON form of a streamed mempool event
+type mempoolEvent struct {
+	Type        string              `json:"type"`
+	TxID        string              `json:"txid"`
+	Transaction storage.Transaction `json:"transaction"`
+}
+
+// handleMempoolEvents streams mempool events as newline-delimited JSON until
+// the client disconnects or falls too far behind
+func (s *Server) handleMempoolEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events := s.Mempool.Subscribe(EventBuffer)
+	defer s.Mempool.Unsubscribe(events)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case event, open := <-events:
+			if !open {
+				return
+			}
+			err := encoder.Encode(mempoolEvent{
+				Type:        event.Type.String(),
+				TxID:        event.Tx.ID(),
+				Transaction: event.Tx,
+			})
+			if err != nil {
+				log.Printf("Failed to stream mempool event: %v", err)
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleBlockTemplate returns a block template paying the address given in
+// the query, or the node's own address
+func (s *Server) handleBlockTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	recipient := r.URL.Query().Get("address")
+	if recipient == "" {
+		recipient = s.Consensus.Coinbase
+	}
+	writeJSON(w, http.StatusOK, s.Consensus.Assembler.NewTemplate(recipient))
+}
+
+// handleSubmitBlock accepts a block mined from a template
+func (s *Server) handleSubmitBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var block blockchain.Block
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, consensus.DefaultMaxBlockSize*2)).Decode(&block); err != nil {
+		http.Error(w, fmt.Sprintf("invalid block: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := s.Consensus.SubmitBlock(block); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"hash": block.Hash, "height": block.Index})
+}
+
+// handleSyncProgress reports how far the chain download has come
+func (s *Server) handleSyncProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.Syncer == nil {
+		http.Error(w, "sync is not running", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Syncer.Progress())
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// Archive format, all integers big endian:
+//
+//	header:  magic "WBCA" | version (u16) | reserved (u16) | genesis hash (32 bytes) | block count (u64)
+//	blocks:  length (u32) | JSON encoded block, repeated block count times
+//	trailer: sha256 of every preceding byte (32 bytes)
+const (
+	ArchiveVersion = 1
+
+	archiveMagic = "WBCA"
+	// maxArchiveBlockSize rejects corrupt length prefixes before allocating
+	maxArchiveBlockSize = 64 << 20
+)
+
+// ErrArchiveChecksum is returned when the archive trailer does not match its contents
+var ErrArchiveChecksum = errors.New("archive checksum mismatch")
+
+// ArchiveHeader describes an archive
+type ArchiveHeader struct {
+	Version     uint16
+	GenesisHash string
+	BlockCount  uint64
+}
+
+// ExportChain streams the whole chain to w in the archive format. Pruned
+// chains cannot be exported because their bodies are gone.
+func (bc *Blockchain) ExportChain(w io.Writer) error {
+	bc.chainMutex.Lock()
+	blocks := append([]Block{}, bc.blocks...)
+	prunedHeight := bc.prunedHeight
+	bc.chainMutex.Unlock()
+
+	if prunedHeight > 0 {
+		return fmt.Errorf("chain is pruned up to height %d, export requires full block bodies", prunedHeight)
+	}
+
+	checksum := sha256.New()
+	buffered := bufio.NewWriter(w)
+	out := io.MultiWriter(buffered, checksum)
+
+	genesis, err := hex.DecodeString(blocks[0].Hash)
+	if err != nil || len(genesis) != sha256.Size {
+		return fmt.Errorf("invalid genesis hash")
+	}
+	header := make([]byte, 0, 48)
+	header = append(header, archiveMagic...)
+	header = binary.BigEndian.AppendUint16(header, ArchiveVersion)
+	header = binary.BigEndian.AppendUint16(header, 0)
+	header = append(header, genesis...)
+	header = binary.BigEndian.AppendUint64(header, uint64(len(blocks)))
+	if _, err := out.Write(header); err != nil {
+		return fmt.Errorf("failed to write archive header: %v", err)
+	}
+
+	for _, block := range blocks {
+		data, err := json.Marshal(block)
+		if err != nil {
+			return fmt.Errorf("failed to encode block %d: %v", block.Index, err)
+		}
+		if _, err := out.Write(binary.BigEndian.AppendUint32(nil, uint32(len(data)))); err != nil {
+			return fmt.Errorf("failed to write block %d: %v", block.Index, err)
+		}
+		if _, err := out.Write(data); err != nil {
+			return fmt.Errorf("failed to write block %d: %v", block.Index, err)
+		}
+	}
+
+	if _, err := buffered.Write(checksum.Sum(nil)); err != nil {
+		return fmt.Errorf("failed to write archive trailer: %v", err)
+	}
+	return buffered.Flush()
+}
+
+// ImportChain reads an archive from r and appends every block that is not
+// already part of the chain, fully validating each one. The blocks are staged
+// in memory and only appended once the trailing checksum matches, so a corrupt
+// or truncated archive leaves the chain untouched. It returns the number of
+// blocks imported.
+func (bc *Blockchain) ImportChain(r io.Reader) (int, error) {
+	checksum := sha256.New()
+	in := io.TeeReader(bufio.NewReader(r), checksum)
+
+	header, err := readArchiveHeader(in)
+	if err != nil {
+		return 0, err
+	}
+	if header.GenesisHash != GenesisBlock().Hash {
+		return 0, fmt.Errorf("archive belongs to a different network (genesis %s)", header.GenesisHash)
+	}
+
+	var staged []Block
+	for i := uint64(0); i < header.BlockCount; i++ {
+		block, err := readArchiveBlock(in)
+		if err != nil {
+			return 0, fmt.Errorf("block %d: %v", i, err)
+		}
+		if block.Index != int(i) {
+			return 0, fmt.Errorf("block %d: archive entry has height %d", i, block.Index)
+		}
+		if existing, ok := bc.blockAt(block.Index); ok {
+			if existing.Hash != block.Hash {
+				return 0, fmt.Errorf("block %d: archive diverges from the local chain", i)
+			}
+			continue
+		}
+		staged = append(staged, block)
+	}
+	if err := verifyArchiveTrailer(in, checksum); err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for _, block := range staged {
+		if err := bc.AppendBlock(block); err != nil {
+			return imported, fmt.Errorf("block %d: %v", block.Index, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// ReadArchiveHeader reads and checks only the header of an archive
+func ReadArchiveHeader(r io.Reader) (ArchiveHeader, error) {
+	return readArchiveHeader(r)
+}
+
+func readArchiveHeader(r io.Reader) (ArchiveHeader, error) {
+	raw := make([]byte, 48)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return ArchiveHeader{}, fmt.Errorf("failed to read archive header: %v", err)
+	}
+	if !bytes.Equal(raw[0:4], []byte(archiveMagic)) {
+		return ArchiveHeader{}, errors.New("not a chain archive")
+	}
+	header := ArchiveHeader{
+		Version:     binary.BigEndian.Uint16(raw[4:6]),
+		GenesisHash: hex.EncodeToString(raw[8:40]),
+		BlockCount:  binary.BigEndian.Uint64(raw[40:48]),
+	}
+	if header.Version != ArchiveVersion {
+		return ArchiveHeader{}, fmt.Errorf("unsupported archive version %d", header.Version)
+	}
+	return header, nil
+}
+
+func readArchiveBlock(r io.Reader) (Block, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return Block{}, fmt.Errorf("failed to read length: %v", err)
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	if length > maxArchiveBlockSize {
+		return Block{}, fmt.Errorf("entry of %d bytes exceeds the size limit", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return Block{}, fmt.Errorf("failed to read data: %v", err)
+	}
+	var block Block
+	if err := json.Unmarshal(data, &block); err != nil {
+		return Block{}, fmt.Errorf("failed to decode: %v", err)
+	}
+	return block, nil
+}
+
+// verifyArchiveTrailer compares the trailing checksum with the hash of
+// everything read so far
+func verifyArchiveTrailer(in io.Reader, checksum hash.Hash) error {
+	expected := checksum.Sum(nil)
+	trailer := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(in, trailer); err != nil {
+		return fmt.Errorf("failed to read archive trailer: %v", err)
+	}
+	if !bytes.Equal(trailer, expected) {
+		return ErrArchiveChecksum
+	}
+	return nil
+}
+
+// blockAt returns the block at height if the chain is that long
+func (bc *Blockchain) blockAt(height int) (Block, bool) {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	if height < 0 || height >= len(bc.blocks) {
+		return Block{}, false
+	}
+	return bc.blocks[height], true
+}
@@ -3,6 +3,7 @@ package blockchain
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -11,9 +12,11 @@ type Block struct {
 	Index        int
 	Timestamp    int64
 	Transactions []Transaction
+	MerkleRoot   string
 	PrevHash     string
 	Hash         string
 	Nonce        int
+	Pruned       bool `json:",omitempty"` // body removed, only the header is kept
 }
 
 func NewBlock(index int, transactions []Transaction, prevHash string) Block {
@@ -21,6 +24,7 @@ func NewBlock(index int, transactions []Transaction, prevHash string) Block {
 		Index:        index,
 		Timestamp:    time.Now().Unix(),
 		Transactions: transactions,
+		MerkleRoot:   ComputeMerkleRoot(transactions),
 		PrevHash:     prevHash,
 		Nonce:        0,
 	}
@@ -29,13 +33,25 @@ func NewBlock(index int, transactions []Transaction, prevHash string) Block {
 	return block
 }
 
-func (b *Block) CalculateHash() string {
-	transactionData := ""
-	for _, tx := range b.Transactions {
-		transactionData += tx.Serialize()
+// genesisTimestamp is fixed so that every node derives the same genesis block
+const genesisTimestamp = 1735689600
+
+// GenesisBlock returns the first block shared by every node on the network
+func GenesisBlock() Block {
+	block := Block{
+		Index:        0,
+		Timestamp:    genesisTimestamp,
+		Transactions: []Transaction{},
+		MerkleRoot:   ComputeMerkleRoot(nil),
 	}
+	block.Hash = block.CalculateHash()
+	return block
+}
 
-	record := string(b.Index) + b.PrevHash + string(b.Timestamp) + transactionData + string(b.Nonce)
+// CalculateHash hashes the block header. Transactions are committed through
+// the Merkle root, so headers can be verified without their bodies.
+func (b *Block) CalculateHash() string {
+	record := strconv.Itoa(b.Index) + b.PrevHash + strconv.FormatInt(b.Timestamp, 10) + b.MerkleRoot + strconv.Itoa(b.Nonce)
 	hash := sha256.Sum256([]byte(record))
 	return hex.EncodeToString(hash[:])
 }
@@ -47,3 +63,15 @@ func (b *Block) MineBlock(difficulty int) {
 		b.Hash = b.CalculateHash()
 	}
 }
+
+// Header returns a copy of the block without its transactions
+func (b Block) Header() Block {
+	b.Transactions = nil
+	b.Pruned = true
+	return b
+}
+
+// MeetsDifficulty reports whether the block hash satisfies the proof-of-work target
+func (b *Block) MeetsDifficulty(difficulty int) bool {
+	return strings.HasPrefix(b.Hash, strings.Repeat("0", difficulty))
+}
@@ -1,59 +1,242 @@
 package blockchain
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 
 	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
 )
 
+// ErrLegacyChain is returned when the data directory holds a chain written by
+// a version incompatible with this network's genesis block
+var ErrLegacyChain = errors.New("data directory holds a chain from an incompatible earlier version")
+
+// DefaultValidationLevel is used by NewBlockchain when loading from disk
+const DefaultValidationLevel = ValidateFull
+
 type Blockchain struct {
-	blocks     []Block
-	difficulty int
-	chainMutex sync.Mutex
-	dataDir    string
+	blocks        []Block
+	state         *State // ledger state at the tip
+	difficulty    int
+	chainMutex    sync.Mutex
+	dataDir       string
+	store         *storage.BlockStore
+	txIndex       *storage.TxIndex
+	indexStale    int            // height the transaction index may be wrong from, 0 when current
+	checkpoints   map[int]string // height -> expected block hash
+	stateHashes   map[int]string // height -> state hash pinned by a checkpoint
+	finalityDepth int
+	pruneDepth    int
+	prunedHeight  int        // bodies at or below this height have been removed
+	snapshots     []Snapshot // state snapshots on disk, ordered by height
+	subscribers   []chan ChainEvent
+	subsMutex     sync.Mutex
 }
 
-func NewBlockchain(dataDir string) *Blockchain {
-	bc := &Blockchain{
-		difficulty: 2,
-		dataDir:    dataDir,
-	}
+// NewBlockchain opens the chain stored in dataDir with the default
+// validation level, creating it if needed
+func NewBlockchain(dataDir string) (*Blockchain, error) {
+	bc, _, err := OpenBlockchain(dataDir, DefaultValidationLevel)
+	return bc, err
+}
+
+// OpenBlockchain loads the chain stored in dataDir, validating it at the
+// given level. Blocks from the first invalid one onwards are discarded and
+// described in the returned report.
+func OpenBlockchain(dataDir string, level ValidationLevel) (*Blockchain, ValidationReport, error) {
+	bc := newBlockchain(dataDir)
 
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		log.Fatalf("Failed to create data directory: %v", err)
+		return nil, ValidationReport{}, fmt.Errorf("failed to create data directory: %v", err)
 	}
 
-	blocks, err := bc.loadBlocks()
+	store, err := storage.OpenBlockStore(dataDir)
+	if err != nil {
+		return nil, ValidationReport{}, fmt.Errorf("failed to open block store: %w", err)
+	}
+	bc.store = store
+	txIndex, err := storage.OpenTxIndex(dataDir)
 	if err != nil {
-		log.Printf("Failed to load blocks from disk: %v", err)
-		genesisBlock := NewBlock(0, []Transaction{}, "")
+		store.Close()
+		return nil, ValidationReport{}, fmt.Errorf("failed to open transaction index: %w", err)
+	}
+	bc.txIndex = txIndex
+	if store.Count() == 0 {
+		if err := bc.migrateLegacyBlocks(); err != nil {
+			bc.Close()
+			return nil, ValidationReport{}, err
+		}
+	}
+	bc.snapshots = bc.loadSnapshots()
+
+	blocks, err := bc.loadBlocks()
+	if err != nil || len(blocks) == 0 {
+		if err != nil {
+			log.Printf("Failed to load blocks from disk: %v", err)
+		}
+		genesisBlock := GenesisBlock()
 		bc.blocks = []Block{genesisBlock}
-		bc.saveBlock(genesisBlock)
-	} else {
-		bc.blocks = blocks
-		log.Println("Blockchain loaded successfully from disk!")
+		bc.state = NewState()
+		if store.Count() == 0 {
+			if err := bc.saveBlock(genesisBlock); err != nil {
+				log.Printf("Failed to save genesis block: %v", err)
+			}
+		}
+		if err := bc.syncIndexLocked(); err != nil {
+			bc.Close()
+			return nil, ValidationReport{}, err
+		}
+		return bc, ValidationReport{Level: level, BlocksChecked: 1}, nil
+	}
+
+	report, state := bc.verifyBlocks(blocks, level)
+	if report.FirstBad != nil {
+		log.Printf("Chain validation: %s", report)
+		if report.FirstBad.Height == 0 {
+			bc.Close()
+			return nil, report, errors.New("stored genesis block does not belong to this network")
+		}
+		// Cutting pruned history back to a block without a snapshot would
+		// leave no state to continue from, so refuse instead
+		if last := blocks[report.FirstBad.Height-1]; last.Pruned && bc.snapshotFor(last) == nil {
+			bc.Close()
+			return nil, report, fmt.Errorf("pruned chain has no state snapshot to continue from at height %d; restore the snapshot or start from a new data directory", last.Index)
+		}
+		blocks = blocks[:report.FirstBad.Height]
+		if err := store.Truncate(uint64(len(blocks) - 1)); err != nil {
+			log.Printf("Failed to discard invalid blocks: %v", err)
+		}
+		bc.dropSnapshotsAboveLocked(len(blocks) - 1)
 	}
+	bc.blocks = blocks
+	bc.state = state
+	for _, block := range blocks {
+		if block.Pruned {
+			bc.prunedHeight = block.Index
+		}
+	}
+	if err := bc.syncIndexLocked(); err != nil {
+		bc.Close()
+		return nil, report, err
+	}
+	log.Println("Blockchain loaded successfully from disk!")
 
-	return bc
+	return bc, report, nil
 }
 
-func (bc *Blockchain) AddBlock(transactions []Transaction) {
+// newBlockchain returns an unopened chain for dataDir with the default settings
+func newBlockchain(dataDir string) *Blockchain {
+	return &Blockchain{
+		difficulty:    2,
+		dataDir:       dataDir,
+		checkpoints:   make(map[int]string),
+		finalityDepth: DefaultFinalityDepth,
+	}
+}
+
+// AppendBlock validates a fully formed block against the current tip and
+// stores it unchanged. The block is persisted before it becomes part of the
+// in-memory chain, so a failed write leaves the chain untouched.
+func (bc *Blockchain) AppendBlock(block Block) error {
 	bc.chainMutex.Lock()
 	defer bc.chainMutex.Unlock()
 
-	lastBlock := bc.blocks[len(bc.blocks)-1]
-	newBlock := NewBlock(len(bc.blocks), transactions, lastBlock.Hash)
-	newBlock.MineBlock(bc.difficulty)
-	bc.blocks = append(bc.blocks, newBlock)
+	parent := bc.blocks[len(bc.blocks)-1]
+	state := bc.state.Clone()
+	if err := bc.verifyBlock(block, parent, state, ValidateFull); err != nil {
+		return fmt.Errorf("block %s: %v", block.Hash, err)
+	}
 
-	err := bc.saveBlock(newBlock)
-	if err != nil {
-		log.Printf("Failed to save block to disk: %v", err)
+	if err := bc.saveBlock(block); err != nil {
+		return fmt.Errorf("failed to save block to disk: %v", err)
+	}
+	bc.blocks = append(bc.blocks, block)
+	bc.state = state
+	bc.indexBlock(block)
+	bc.repairIndexLocked()
+	bc.maintainLocked()
+	bc.notify(BlockConnected, block)
+	return nil
+}
+
+// BlockError reports a block that failed validation
+type BlockError struct {
+	Hash string
+	Err  error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("block %s: %v", e.Hash, e.Err)
+}
+
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// Reorganize replaces every block above forkHeight with newBlocks and returns
+// the blocks that were disconnected. Reorgs that would undo finalized blocks,
+// fork below pruned history or conflict with a checkpoint are refused. A new
+// block that fails validation is reported as a *BlockError.
+func (bc *Blockchain) Reorganize(forkHeight int, newBlocks []Block) ([]Block, error) {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	if forkHeight < 0 || forkHeight >= len(bc.blocks) {
+		return nil, fmt.Errorf("fork height %d out of range", forkHeight)
+	}
+	if forkHeight < bc.finalizedHeightLocked() {
+		return nil, fmt.Errorf("%w: fork at %d, finalized at %d", ErrFinalityViolation, forkHeight, bc.finalizedHeightLocked())
 	}
+
+	if forkHeight < bc.prunedHeight {
+		return nil, fmt.Errorf("%w: fork at %d, pruned up to %d", ErrPrunedFork, forkHeight, bc.prunedHeight)
+	}
+
+	report, state := bc.verifyBlocks(bc.blocks[:forkHeight+1], ValidateQuick)
+	if report.FirstBad != nil {
+		return nil, fmt.Errorf("cannot rebuild the state at fork height %d: %s", forkHeight, report)
+	}
+	prev := bc.blocks[forkHeight]
+	for _, block := range newBlocks {
+		if err := bc.verifyBlock(block, prev, state, ValidateFull); err != nil {
+			return nil, &BlockError{Hash: block.Hash, Err: err}
+		}
+		prev = block
+	}
+
+	disconnected := append([]Block{}, bc.blocks[forkHeight+1:]...)
+	if err := bc.store.Truncate(uint64(forkHeight)); err != nil {
+		return nil, fmt.Errorf("failed to remove blocks from disk: %v", err)
+	}
+	bc.blocks = bc.blocks[: forkHeight+1 : forkHeight+1]
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		bc.unindexBlock(disconnected[i])
+	}
+	for _, block := range newBlocks {
+		if err := bc.saveBlock(block); err != nil {
+			// Keep memory consistent with what reached the disk
+			_, bc.state = bc.verifyBlocks(bc.blocks, ValidateQuick)
+			bc.notify(BlockDisconnected, disconnected...)
+			return disconnected, fmt.Errorf("failed to save block to disk: %v", err)
+		}
+		bc.blocks = append(bc.blocks, block)
+		bc.indexBlock(block)
+	}
+	bc.repairIndexLocked()
+	bc.state = state
+	bc.dropSnapshotsAboveLocked(forkHeight)
+
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		bc.notify(BlockDisconnected, disconnected[i])
+	}
+	bc.notify(BlockConnected, newBlocks...)
+	return disconnected, nil
 }
 
 func (bc *Blockchain) GetBlocks() []Block {
@@ -62,6 +245,30 @@ func (bc *Blockchain) GetBlocks() []Block {
 	return bc.blocks
 }
 
+// GetBlockByHash looks up a block in the chain by its hash
+func (bc *Blockchain) GetBlockByHash(hash string) (Block, bool) {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	for _, block := range bc.blocks {
+		if block.Hash == hash {
+			return block, true
+		}
+	}
+	return Block{}, false
+}
+
+// GetBlockByHeight returns the block at height in the chain
+func (bc *Blockchain) GetBlockByHeight(height int) (Block, bool) {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	if height < 0 || height >= len(bc.blocks) {
+		return Block{}, false
+	}
+	return bc.blocks[height], true
+}
+
 func (bc *Blockchain) IsValid() bool {
 	bc.chainMutex.Lock()
 	defer bc.chainMutex.Unlock()
@@ -82,12 +289,112 @@ func (bc *Blockchain) IsValid() bool {
 	return true
 }
 
+// Difficulty returns the proof-of-work difficulty required for new blocks
+func (bc *Blockchain) Difficulty() int {
+	return bc.difficulty
+}
+
+// Height returns the index of the current tip
+func (bc *Blockchain) Height() int {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	return len(bc.blocks) - 1
+}
+
+// NextNonce returns the nonce the sender's next transaction must use
+func (bc *Blockchain) NextNonce(sender string) uint64 {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	return bc.state.NextNonce(sender)
+}
+
+// Balance returns the amount held by address at the tip
+func (bc *Blockchain) Balance(address string) uint64 {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	return bc.state.Balance(address)
+}
+
+// State returns a copy of the ledger state at the tip
+func (bc *Blockchain) State() *State {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	return bc.state.Clone()
+}
+
+// Close flushes and releases the block store and indexes
+func (bc *Blockchain) Close() error {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	var errIndex error
+	if bc.txIndex != nil {
+		errIndex = bc.txIndex.Close()
+	}
+	if err := bc.store.Close(); err != nil {
+		return err
+	}
+	return errIndex
+}
+
 func (bc *Blockchain) saveBlock(block Block) error {
-	fileName := fmt.Sprintf("block_%d.json", block.Index)
-	return storage.SaveData(block, fileName, bc.dataDir)
+	data, err := json.Marshal(block)
+	if err != nil {
+		return fmt.Errorf("failed to encode block: %v", err)
+	}
+	return bc.store.Append(uint64(block.Index), block.Hash, data)
 }
 
+// loadBlocks reads every stored block in height order
 func (bc *Blockchain) loadBlocks() ([]Block, error) {
+	count := bc.store.Count()
+	blocks := make([]Block, 0, count)
+	for height := uint64(0); height < count; height++ {
+		data, err := bc.store.Get(height)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read block %d: %v", height, err)
+		}
+		var block Block
+		if err := json.Unmarshal(data, &block); err != nil {
+			return nil, fmt.Errorf("failed to decode block %d: %v", height, err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// migrateLegacyBlocks imports block_N.json files written before the block
+// store, leaving the files in place. Files from versions before the fixed
+// genesis block and header hash cannot be migrated: their hashes and unsigned
+// transactions are invalid on this network, so ErrLegacyChain is returned
+// rather than discarding them.
+func (bc *Blockchain) migrateLegacyBlocks() error {
+	blocks, err := bc.loadLegacyBlocks()
+	if err != nil {
+		log.Printf("Failed to read legacy block files: %v", err)
+		return nil
+	}
+	if len(blocks) > 0 && blocks[0].Hash != GenesisBlock().Hash {
+		return fmt.Errorf("%w in %s; move the block_*.json files aside to start a new chain", ErrLegacyChain, bc.dataDir)
+	}
+	for i, block := range blocks {
+		if block.Index != i {
+			log.Printf("Legacy block files have a gap at height %d, stopping import", i)
+			return nil
+		}
+		if err := bc.saveBlock(block); err != nil {
+			log.Printf("Failed to import legacy block %d: %v", i, err)
+			return nil
+		}
+	}
+	if len(blocks) > 0 {
+		log.Printf("Imported %d legacy block files into the block store", len(blocks))
+	}
+	return nil
+}
+
+// loadLegacyBlocks reads block_N.json files ordered by height
+func (bc *Blockchain) loadLegacyBlocks() ([]Block, error) {
 	fileNames, err := storage.ListFiles(bc.dataDir)
 	if err != nil {
 		return nil, err
@@ -95,7 +402,8 @@ func (bc *Blockchain) loadBlocks() ([]Block, error) {
 
 	var blocks []Block
 	for _, fileName := range fileNames {
-		if filepath.Ext(fileName) != ".json" {
+		var index int
+		if _, err := fmt.Sscanf(fileName, "block_%d.json", &index); err != nil || filepath.Ext(fileName) != ".json" {
 			continue
 		}
 
@@ -107,5 +415,6 @@ func (bc *Blockchain) loadBlocks() ([]Block, error) {
 		blocks = append(blocks, block)
 	}
 
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].Index < blocks[j].Index })
 	return blocks, nil
 }
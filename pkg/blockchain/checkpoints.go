@@ -0,0 +1,156 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// DefaultFinalityDepth is the number of confirmations after which a block can
+// no longer be reorganized away
+const DefaultFinalityDepth = 100
+
+var (
+	// ErrCheckpointMismatch is returned when a block conflicts with a checkpoint
+	ErrCheckpointMismatch = errors.New("block conflicts with checkpoint")
+	// ErrFinalityViolation is returned when a reorg would undo finalized blocks
+	ErrFinalityViolation = errors.New("reorganization below finalized height")
+)
+
+// Checkpoint pins the block hash expected at a given height and, optionally,
+// the hash of the state after that block, which snapshots must match
+type Checkpoint struct {
+	Height    int    `json:"height"`
+	Hash      string `json:"hash"`
+	StateHash string `json:"state_hash,omitempty"`
+}
+
+// CheckpointList is the signed checkpoint file shipped by operators
+type CheckpointList struct {
+	Checkpoints []Checkpoint `json:"checkpoints"`
+	Signature   string       `json:"signature"` // hex ed25519 signature over the checkpoints
+}
+
+// SignCheckpoints produces a signed checkpoint list
+func SignCheckpoints(checkpoints []Checkpoint, key ed25519.PrivateKey) (CheckpointList, error) {
+	message, err := checkpointMessage(checkpoints)
+	if err != nil {
+		return CheckpointList{}, err
+	}
+	return CheckpointList{
+		Checkpoints: checkpoints,
+		Signature:   hex.EncodeToString(ed25519.Sign(key, message)),
+	}, nil
+}
+
+// LoadCheckpoints reads a signed checkpoint file and verifies it against the
+// operator public key (hex encoded)
+func LoadCheckpoints(path string, publicKeyHex string) ([]Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint file: %v", err)
+	}
+
+	var list CheckpointList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("failed to decode checkpoint file: %v", err)
+	}
+
+	publicKey, err := hex.DecodeString(publicKeyHex)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid checkpoint public key")
+	}
+	signature, err := hex.DecodeString(list.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid checkpoint signature encoding: %v", err)
+	}
+	message, err := checkpointMessage(list.Checkpoints)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(publicKey, message, signature) {
+		return nil, fmt.Errorf("checkpoint signature verification failed")
+	}
+
+	return list.Checkpoints, nil
+}
+
+// checkpointMessage returns the canonical bytes covered by the signature
+func checkpointMessage(checkpoints []Checkpoint) ([]byte, error) {
+	sorted := append([]Checkpoint{}, checkpoints...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Height < sorted[j].Height })
+	message, err := json.Marshal(sorted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode checkpoints: %v", err)
+	}
+	return message, nil
+}
+
+// SetCheckpoints installs the given checkpoints. It fails if the current chain
+// already conflicts with one of them.
+func (bc *Blockchain) SetCheckpoints(checkpoints []Checkpoint) error {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	pinned := make(map[int]string, len(checkpoints))
+	stateHashes := make(map[int]string)
+	for _, cp := range checkpoints {
+		if cp.Height < len(bc.blocks) && bc.blocks[cp.Height].Hash != cp.Hash {
+			return fmt.Errorf("%w at height %d", ErrCheckpointMismatch, cp.Height)
+		}
+		pinned[cp.Height] = cp.Hash
+		if cp.StateHash != "" {
+			stateHashes[cp.Height] = cp.StateHash
+		}
+	}
+	bc.checkpoints = pinned
+	bc.stateHashes = stateHashes
+	return nil
+}
+
+// SetFinalityDepth sets the number of confirmations after which reorgs are
+// refused. Zero disables depth-based finality.
+func (bc *Blockchain) SetFinalityDepth(depth int) {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	bc.finalityDepth = depth
+}
+
+// CheckCheckpoint verifies that hash is acceptable at height
+func (bc *Blockchain) CheckCheckpoint(height int, hash string) error {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	return bc.checkCheckpointLocked(height, hash)
+}
+
+// FinalizedHeight returns the height at or below which blocks are final
+func (bc *Blockchain) FinalizedHeight() int {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	return bc.finalizedHeightLocked()
+}
+
+func (bc *Blockchain) checkCheckpointLocked(height int, hash string) error {
+	if expected, exists := bc.checkpoints[height]; exists && expected != hash {
+		return fmt.Errorf("%w at height %d", ErrCheckpointMismatch, height)
+	}
+	return nil
+}
+
+func (bc *Blockchain) finalizedHeightLocked() int {
+	tip := len(bc.blocks) - 1
+	finalized := 0
+	if bc.finalityDepth > 0 && tip-bc.finalityDepth > finalized {
+		finalized = tip - bc.finalityDepth
+	}
+	for height := range bc.checkpoints {
+		if height <= tip && height > finalized {
+			finalized = height
+		}
+	}
+	return finalized
+}
@@ -0,0 +1,30 @@
+package blockchain
+
+// BlockReward is the amount a coinbase may claim on top of its block's fees
+const BlockReward = 50
+
+// NewCoinbase creates the transaction paying a miner for the block at height.
+// The height keeps coinbase IDs unique across blocks.
+func NewCoinbase(recipient string, height int, amount uint64) Transaction {
+	return Transaction{
+		Recipient: recipient,
+		Amount:    amount,
+		Nonce:     uint64(height),
+	}
+}
+
+// IsCoinbase reports whether t pays a block reward rather than recording a result
+func (t Transaction) IsCoinbase() bool {
+	return t.Sender == "" && t.Recipient != ""
+}
+
+// BlockFees returns the fees paid by the non-coinbase transactions in txs
+func BlockFees(txs []Transaction) uint64 {
+	var fees uint64
+	for _, tx := range txs {
+		if !tx.IsCoinbase() {
+			fees += tx.Fee
+		}
+	}
+	return fees
+}
@@ -0,0 +1,57 @@
+package blockchain
+
+// ChainEventType identifies what happened to a block
+type ChainEventType int
+
+const (
+	// BlockConnected is sent when a block becomes part of the main chain
+	BlockConnected ChainEventType = iota
+	// BlockDisconnected is sent when a block is removed by a reorganization
+	BlockDisconnected
+)
+
+// ChainEvent notifies subscribers of changes to the main chain
+type ChainEvent struct {
+	Type  ChainEventType
+	Block Block
+}
+
+// Subscribe returns a channel that receives chain events. Events are dropped
+// for subscribers whose buffer is full.
+func (bc *Blockchain) Subscribe(buffer int) <-chan ChainEvent {
+	bc.subsMutex.Lock()
+	defer bc.subsMutex.Unlock()
+
+	ch := make(chan ChainEvent, buffer)
+	bc.subscribers = append(bc.subscribers, ch)
+	return ch
+}
+
+// Unsubscribe stops delivery to a channel returned by Subscribe and closes it
+func (bc *Blockchain) Unsubscribe(sub <-chan ChainEvent) {
+	bc.subsMutex.Lock()
+	defer bc.subsMutex.Unlock()
+
+	for i, ch := range bc.subscribers {
+		if ch == sub {
+			bc.subscribers = append(bc.subscribers[:i], bc.subscribers[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+func (bc *Blockchain) notify(eventType ChainEventType, blocks ...Block) {
+	bc.subsMutex.Lock()
+	defer bc.subsMutex.Unlock()
+
+	for _, block := range blocks {
+		event := ChainEvent{Type: eventType, Block: block}
+		for _, ch := range bc.subscribers {
+			select {
+			case ch <- event:
+			default:
+			}
+		}
+	}
+}
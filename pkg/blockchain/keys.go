@@ -0,0 +1,43 @@
+package blockchain
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// AddressFromKey returns the address (hex public key) for a signing key
+func AddressFromKey(key ed25519.PrivateKey) string {
+	return hex.EncodeToString(key.Public().(ed25519.PublicKey))
+}
+
+// LoadOrCreateKey reads a hex-encoded ed25519 key from path, generating and
+// saving a new one if the file does not exist
+func LoadOrCreateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(string(data))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid key file %s", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Seed())), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write key file: %v", err)
+	}
+	return key, nil
+}
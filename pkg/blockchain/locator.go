@@ -0,0 +1,48 @@
+package blockchain
+
+// locatorDenseHashes is how many recent blocks a locator lists one by one
+// before the gaps start doubling
+const locatorDenseHashes = 10
+
+// Locator returns hashes of main-chain blocks from the tip back to genesis,
+// dense near the tip and exponentially sparser further back, so a peer can
+// find where our chains diverge in a single round trip
+func (bc *Blockchain) Locator() []string {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	var hashes []string
+	step := 1
+	for height := len(bc.blocks) - 1; height > 0; height -= step {
+		hashes = append(hashes, bc.blocks[height].Hash)
+		if len(hashes) >= locatorDenseHashes {
+			step *= 2
+		}
+	}
+	return append(hashes, bc.blocks[0].Hash)
+}
+
+// HeadersAfter returns up to max headers of the main chain following the
+// first locator hash we know, ending early at stopHash. Without a known
+// locator hash the headers start right after genesis.
+func (bc *Blockchain) HeadersAfter(locator []string, stopHash string, max int) []Block {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	start := 0
+	for _, hash := range locator {
+		if height, err := bc.store.HeightOf(hash); err == nil && int(height) < len(bc.blocks) && bc.blocks[height].Hash == hash {
+			start = int(height)
+			break
+		}
+	}
+
+	var headers []Block
+	for height := start + 1; height < len(bc.blocks) && len(headers) < max; height++ {
+		headers = append(headers, bc.blocks[height].Header())
+		if bc.blocks[height].Hash == stopHash {
+			break
+		}
+	}
+	return headers
+}
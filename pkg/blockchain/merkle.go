@@ -0,0 +1,32 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ComputeMerkleRoot returns the Merkle root of the given transactions. The
+// last hash of an odd-sized level is paired with itself.
+func ComputeMerkleRoot(transactions []Transaction) string {
+	if len(transactions) == 0 {
+		return calculateHash("")
+	}
+
+	level := make([][32]byte, len(transactions))
+	for i, tx := range transactions {
+		level[i] = sha256.Sum256([]byte(tx.Serialize()))
+	}
+
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([][32]byte, len(level)/2)
+		for i := range next {
+			next[i] = sha256.Sum256(append(level[2*i][:], level[2*i+1][:]...))
+		}
+		level = next
+	}
+
+	return hex.EncodeToString(level[0][:])
+}
@@ -0,0 +1,90 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// ErrPrunedFork is returned when a reorg would fork below pruned history,
+// whose state can no longer be replayed
+var ErrPrunedFork = errors.New("reorganization below pruned history")
+
+// SetPruneDepth enables pruning of block bodies more than depth blocks below
+// the tip. Zero disables pruning. The depth is never lower than the finality
+// depth, so reorganizations always find the bodies they need.
+func (bc *Blockchain) SetPruneDepth(depth int) {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	if depth > 0 && depth < bc.finalityDepth {
+		depth = bc.finalityDepth
+	}
+	bc.pruneDepth = depth
+}
+
+// Prune drops the bodies of blocks below the prune depth, keeping only their
+// headers. Bodies are only removed up to the latest state snapshot, which
+// replaces them when the chain is replayed.
+func (bc *Blockchain) Prune() error {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	return bc.pruneLocked()
+}
+
+// PrunedHeight returns the height up to which block bodies have been removed
+func (bc *Blockchain) PrunedHeight() int {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	return bc.prunedHeight
+}
+
+func (bc *Blockchain) pruneLocked() error {
+	if bc.pruneDepth == 0 {
+		return nil
+	}
+	limit := len(bc.blocks) - 1 - bc.pruneDepth
+	snapshot := bc.snapshotAt(limit)
+	if snapshot == nil || snapshot.Height <= bc.prunedHeight {
+		return nil
+	}
+	target := snapshot.Height
+
+	err := bc.store.Compact(uint64(target), func(height uint64, data []byte) []byte {
+		if height == 0 {
+			return data
+		}
+		header, err := json.Marshal(bc.blocks[height].Header())
+		if err != nil {
+			return data
+		}
+		return header
+	})
+	if err != nil {
+		return fmt.Errorf("failed to prune block bodies: %v", err)
+	}
+
+	for i := 1; i <= target; i++ {
+		bc.blocks[i] = bc.blocks[i].Header()
+	}
+	bc.prunedHeight = target
+	bc.trimSnapshotsLocked()
+	log.Printf("Pruned block bodies up to height %d", target)
+	return nil
+}
+
+// maintainLocked takes periodic snapshots and prunes after a block is connected
+func (bc *Blockchain) maintainLocked() {
+	tip := bc.blocks[len(bc.blocks)-1]
+	if tip.Index%SnapshotInterval != 0 {
+		return
+	}
+	if _, err := bc.takeSnapshotLocked(); err != nil {
+		log.Printf("Failed to take state snapshot: %v", err)
+		return
+	}
+	if err := bc.pruneLocked(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,226 @@
+package blockchain
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+// IndexField names a transaction field that can be queried
+type IndexField string
+
+const (
+	IndexDataset   IndexField = "dataset"
+	IndexAlgorithm IndexField = "algorithm"
+	IndexCentroid  IndexField = "centroid"
+	IndexSender    IndexField = "sender"
+)
+
+const (
+	// DefaultPageSize is used when a query does not specify a limit
+	DefaultPageSize = 50
+	// MaxPageSize caps the number of results returned by one query
+	MaxPageSize = 500
+)
+
+// Page selects a slice of query results. Cursor is the NextCursor of the
+// previous page, or empty for the first page.
+type Page struct {
+	Cursor string
+	Limit  int
+}
+
+// TxResult is a transaction together with where it was included. For
+// blocks whose body has been pruned only the location is known.
+type TxResult struct {
+	TxID        string
+	Transaction Transaction
+	BlockHeight int
+	BlockHash   string
+	Position    int
+	Pruned      bool
+}
+
+// QueryResult is one page of query results
+type QueryResult struct {
+	Results    []TxResult
+	NextCursor string // empty when there are no more results
+}
+
+// GetTransaction looks up a confirmed transaction by ID
+func (bc *Blockchain) GetTransaction(id string) (TxResult, error) {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	if err := bc.currentIndexLocked(); err != nil {
+		return TxResult{}, err
+	}
+	loc, err := bc.txIndex.Lookup(id)
+	if err != nil {
+		return TxResult{}, err
+	}
+	return bc.resolveLocked(loc)
+}
+
+// QueryTransactions returns the confirmed transactions whose field equals
+// value, ordered by block height
+func (bc *Blockchain) QueryTransactions(field IndexField, value string, page Page) (QueryResult, error) {
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	// The lock keeps the index and the blocks it points into consistent
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	if err := bc.currentIndexLocked(); err != nil {
+		return QueryResult{}, err
+	}
+	locations, next, err := bc.txIndex.Query(string(field), value, page.Cursor, limit)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	result := QueryResult{NextCursor: next}
+	for _, loc := range locations {
+		tx, err := bc.resolveLocked(loc)
+		if err != nil {
+			return QueryResult{}, err
+		}
+		result.Results = append(result.Results, tx)
+	}
+	return result, nil
+}
+
+func (bc *Blockchain) resolveLocked(loc storage.TxLocation) (TxResult, error) {
+	if loc.Height >= uint64(len(bc.blocks)) {
+		return TxResult{}, fmt.Errorf("transaction %s points past the tip", loc.TxID)
+	}
+	block := bc.blocks[loc.Height]
+	if block.Pruned {
+		return TxResult{
+			TxID:        loc.TxID,
+			BlockHeight: block.Index,
+			BlockHash:   block.Hash,
+			Position:    int(loc.Position),
+			Pruned:      true,
+		}, nil
+	}
+	if int(loc.Position) >= len(block.Transactions) {
+		return TxResult{}, fmt.Errorf("transaction %s not found in block %d", loc.TxID, loc.Height)
+	}
+	return TxResult{
+		TxID:        loc.TxID,
+		Transaction: block.Transactions[loc.Position],
+		BlockHeight: block.Index,
+		BlockHash:   block.Hash,
+		Position:    int(loc.Position),
+	}, nil
+}
+
+// indexBlock adds a connected block to the transaction indexes. A failed
+// update marks the index stale from the block's height; while it is stale,
+// blocks are left for syncIndexLocked to index.
+func (bc *Blockchain) indexBlock(block Block) {
+	if bc.indexStale > 0 {
+		return
+	}
+	if err := bc.txIndex.IndexBlock(uint64(block.Index), indexedTransactions(block)); err != nil {
+		log.Printf("Failed to index block %d: %v", block.Index, err)
+		bc.indexStale = block.Index
+	}
+}
+
+// unindexBlock removes a disconnected block from the transaction indexes,
+// marking the index stale from the block's height if that fails
+func (bc *Blockchain) unindexBlock(block Block) {
+	if bc.indexStale > 0 {
+		if block.Index < bc.indexStale {
+			bc.indexStale = block.Index
+		}
+		return
+	}
+	if err := bc.txIndex.RemoveBlock(uint64(block.Index), indexedTransactions(block)); err != nil {
+		log.Printf("Failed to unindex block %d: %v", block.Index, err)
+		bc.indexStale = block.Index
+	}
+}
+
+// repairIndexLocked rebuilds a stale transaction index. On failure the index
+// stays stale and the next update or query tries again.
+func (bc *Blockchain) repairIndexLocked() {
+	if bc.indexStale == 0 {
+		return
+	}
+	if err := bc.syncIndexLocked(); err != nil {
+		log.Printf("Failed to rebuild the transaction index: %v", err)
+	}
+}
+
+// currentIndexLocked rebuilds a stale transaction index before a query, so
+// that queries fail rather than return wrong results
+func (bc *Blockchain) currentIndexLocked() error {
+	if bc.indexStale == 0 {
+		return nil
+	}
+	if err := bc.syncIndexLocked(); err != nil {
+		return fmt.Errorf("transaction index is out of date: %v", err)
+	}
+	return nil
+}
+
+// syncIndexLocked brings the transaction indexes in line with the loaded
+// chain after a restart, an interrupted update or a failed one
+func (bc *Blockchain) syncIndexLocked() error {
+	tip, indexed := bc.txIndex.Tip()
+	next := 0
+	if indexed {
+		next = int(tip) + 1
+	}
+	keep := len(bc.blocks) - 1
+	if bc.indexStale > 0 && bc.indexStale <= keep {
+		// Entries from the failed update on may not match the chain
+		keep = bc.indexStale - 1
+	}
+	if next > keep+1 {
+		// Indexed blocks beyond the loaded tip were discarded or are stale.
+		// Dropping their entries by height keeps those of pruned blocks,
+		// whose contents could not be indexed again.
+		log.Printf("Transaction index is ahead of the chain, truncating it to height %d", keep)
+		if err := bc.txIndex.TruncateAbove(uint64(keep)); err != nil {
+			return fmt.Errorf("failed to truncate transaction index: %v", err)
+		}
+		next = keep + 1
+	}
+	for _, block := range bc.blocks[next:] {
+		if err := bc.txIndex.IndexBlock(uint64(block.Index), indexedTransactions(block)); err != nil {
+			return fmt.Errorf("failed to index block %d: %v", block.Index, err)
+		}
+	}
+	bc.indexStale = 0
+	return nil
+}
+
+func indexedTransactions(block Block) []storage.IndexedTx {
+	txs := make([]storage.IndexedTx, len(block.Transactions))
+	for i, tx := range block.Transactions {
+		fields := map[string]string{
+			string(IndexDataset):   tx.DatasetHash,
+			string(IndexAlgorithm): tx.AlgorithmHash,
+			string(IndexCentroid):  tx.CentroidHash,
+			string(IndexSender):    tx.Sender,
+		}
+		for field, value := range fields {
+			if value == "" {
+				delete(fields, field) // coinbase transactions carry no result
+			}
+		}
+		txs[i] = storage.IndexedTx{ID: tx.ID(), Fields: fields}
+	}
+	return txs
+}
@@ -0,0 +1,323 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+const (
+	// SnapshotInterval is the number of blocks between automatic state snapshots
+	SnapshotInterval = 100
+	// snapshotsKept is the number of snapshots retained on disk
+	snapshotsKept = 2
+	snapshotDir   = "snapshots"
+)
+
+var (
+	// ErrSnapshotMismatch is returned when a snapshot does not match the chain
+	ErrSnapshotMismatch = errors.New("snapshot does not match chain")
+	// ErrUntrustedSnapshot is returned for a snapshot whose state no
+	// checkpoint pins
+	ErrUntrustedSnapshot = errors.New("snapshot state is not pinned by a checkpoint")
+)
+
+// Snapshot is the ledger state after the block at Height
+type Snapshot struct {
+	Height    int
+	BlockHash string
+	State     *State
+	StateHash string // sha256 of the JSON encoded state
+}
+
+// NewSnapshot builds a snapshot of state at the given block
+func NewSnapshot(block Block, state *State) (Snapshot, error) {
+	hash, err := hashState(state)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{Height: block.Index, BlockHash: block.Hash, State: state.Clone(), StateHash: hash}, nil
+}
+
+// Verify checks that the snapshot's state matches its state hash
+func (s Snapshot) Verify() error {
+	if s.State == nil {
+		return errors.New("snapshot has no state")
+	}
+	hash, err := hashState(s.State)
+	if err != nil {
+		return err
+	}
+	if hash != s.StateHash {
+		return errors.New("snapshot state hash mismatch")
+	}
+	return nil
+}
+
+// LoadSnapshotFile reads and verifies a snapshot file
+func LoadSnapshotFile(path string) (Snapshot, error) {
+	var snapshot Snapshot
+	if err := storage.LoadData(filepath.Base(path), filepath.Dir(path), &snapshot); err != nil {
+		return Snapshot{}, err
+	}
+	if err := snapshot.Verify(); err != nil {
+		return Snapshot{}, err
+	}
+	return snapshot, nil
+}
+
+// TakeSnapshot saves a snapshot of the state at the current tip
+func (bc *Blockchain) TakeSnapshot() (Snapshot, error) {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+	return bc.takeSnapshotLocked()
+}
+
+// ImportSnapshot installs a snapshot, typically produced by another node, so
+// that the chain can start from it instead of replaying every block. The
+// snapshot must match a block header already in the chain, and a checkpoint
+// must pin its block and state hash.
+func (bc *Blockchain) ImportSnapshot(snapshot Snapshot) error {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	if err := bc.checkSnapshotTrustedLocked(snapshot); err != nil {
+		return err
+	}
+	if snapshot.Height >= len(bc.blocks) {
+		return fmt.Errorf("%w: height %d is above the tip", ErrSnapshotMismatch, snapshot.Height)
+	}
+	if bc.blocks[snapshot.Height].Hash != snapshot.BlockHash {
+		return fmt.Errorf("%w: block hash at height %d", ErrSnapshotMismatch, snapshot.Height)
+	}
+
+	state := snapshot.State.Clone()
+	for _, block := range bc.blocks[snapshot.Height+1:] {
+		if block.Pruned {
+			return fmt.Errorf("block %d above the snapshot has no body", block.Index)
+		}
+		if err := state.ApplyBlock(block); err != nil {
+			return fmt.Errorf("block %d does not apply on the snapshot: %v", block.Index, err)
+		}
+	}
+
+	if err := bc.saveSnapshot(snapshot); err != nil {
+		return err
+	}
+	bc.snapshots = append(bc.snapshots, snapshot)
+	sort.Slice(bc.snapshots, func(i, j int) bool { return bc.snapshots[i].Height < bc.snapshots[j].Height })
+	bc.state = state
+	return nil
+}
+
+// SnapshotBundle is a snapshot with the headers of every block up to it,
+// enough for a new node to start from the snapshot without block bodies
+type SnapshotBundle struct {
+	Headers  []Block
+	Snapshot Snapshot
+}
+
+// ExportSnapshot builds a snapshot of the state at the tip and bundles it
+// with the chain's headers. Nothing is written to the data directory.
+func (bc *Blockchain) ExportSnapshot() (SnapshotBundle, error) {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	snapshot, err := NewSnapshot(bc.blocks[len(bc.blocks)-1], bc.state)
+	if err != nil {
+		return SnapshotBundle{}, err
+	}
+	headers := make([]Block, len(bc.blocks))
+	for i, block := range bc.blocks {
+		headers[i] = block.Header()
+	}
+	headers[0] = bc.blocks[0]
+	return SnapshotBundle{Headers: headers, Snapshot: snapshot}, nil
+}
+
+// StartFromSnapshot installs a bundle on a chain that holds only the genesis
+// block: the headers are validated and stored as pruned blocks and the
+// snapshot becomes the state at their tip. Sync then continues from there.
+// As for ImportSnapshot, a checkpoint must pin the snapshot.
+func (bc *Blockchain) StartFromSnapshot(bundle SnapshotBundle) error {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	snapshot := bundle.Snapshot
+	if len(bc.blocks) != 1 {
+		return fmt.Errorf("chain already has %d blocks past genesis", len(bc.blocks)-1)
+	}
+	if err := bc.checkSnapshotTrustedLocked(snapshot); err != nil {
+		return err
+	}
+	headers := bundle.Headers
+	if len(headers) != snapshot.Height+1 || headers[len(headers)-1].Hash != snapshot.BlockHash {
+		return fmt.Errorf("%w: headers do not end at the snapshot block", ErrSnapshotMismatch)
+	}
+	if headers[0].Hash != bc.blocks[0].Hash {
+		return fmt.Errorf("%w: different genesis block", ErrSnapshotMismatch)
+	}
+	blocks := []Block{bc.blocks[0]}
+	for _, header := range headers[1:] {
+		header = header.Header()
+		if err := bc.verifyBlock(header, blocks[len(blocks)-1], NewState(), ValidateQuick); err != nil {
+			return fmt.Errorf("header %d: %v", header.Index, err)
+		}
+		blocks = append(blocks, header)
+	}
+
+	// Save the snapshot first: headers without it could not be replayed
+	if err := bc.saveSnapshot(snapshot); err != nil {
+		return err
+	}
+	for _, block := range blocks[1:] {
+		if err := bc.saveBlock(block); err != nil {
+			return fmt.Errorf("failed to save header %d: %v", block.Index, err)
+		}
+		bc.blocks = append(bc.blocks, block)
+		bc.indexBlock(block)
+	}
+	bc.snapshots = []Snapshot{snapshot}
+	bc.state = snapshot.State.Clone()
+	bc.prunedHeight = snapshot.Height
+	return nil
+}
+
+// checkSnapshotTrustedLocked verifies a snapshot from outside this node. Its
+// state hash only proves that the state was not damaged, so the block and
+// state hash must also match a signed checkpoint.
+func (bc *Blockchain) checkSnapshotTrustedLocked(snapshot Snapshot) error {
+	if err := snapshot.Verify(); err != nil {
+		return err
+	}
+	expected, pinned := bc.stateHashes[snapshot.Height]
+	if !pinned {
+		return fmt.Errorf("%w at height %d", ErrUntrustedSnapshot, snapshot.Height)
+	}
+	if expected != snapshot.StateHash || bc.checkpoints[snapshot.Height] != snapshot.BlockHash {
+		return fmt.Errorf("%w: checkpoint at height %d", ErrSnapshotMismatch, snapshot.Height)
+	}
+	return nil
+}
+
+func (bc *Blockchain) takeSnapshotLocked() (Snapshot, error) {
+	tip := bc.blocks[len(bc.blocks)-1]
+	snapshot, err := NewSnapshot(tip, bc.state)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if err := bc.saveSnapshot(snapshot); err != nil {
+		return Snapshot{}, err
+	}
+
+	bc.snapshots = append(bc.snapshots, snapshot)
+	bc.trimSnapshotsLocked()
+	return snapshot, nil
+}
+
+// trimSnapshotsLocked deletes old snapshots, keeping the newest ones and the
+// one that pruned history depends on
+func (bc *Blockchain) trimSnapshotsLocked() {
+	var kept []Snapshot
+	for i, snapshot := range bc.snapshots {
+		if i >= len(bc.snapshots)-snapshotsKept || (bc.prunedHeight > 0 && snapshot.Height == bc.prunedHeight) {
+			kept = append(kept, snapshot)
+			continue
+		}
+		if err := os.Remove(filepath.Join(bc.dataDir, snapshotDir, snapshotFileName(snapshot.Height))); err != nil {
+			log.Printf("Failed to remove old snapshot: %v", err)
+		}
+	}
+	bc.snapshots = kept
+}
+
+// dropSnapshotsAboveLocked removes snapshots of blocks that were disconnected
+func (bc *Blockchain) dropSnapshotsAboveLocked(height int) {
+	var kept []Snapshot
+	for _, snapshot := range bc.snapshots {
+		if snapshot.Height <= height {
+			kept = append(kept, snapshot)
+			continue
+		}
+		if err := os.Remove(filepath.Join(bc.dataDir, snapshotDir, snapshotFileName(snapshot.Height))); err != nil {
+			log.Printf("Failed to remove stale snapshot: %v", err)
+		}
+	}
+	bc.snapshots = kept
+}
+
+// snapshotAt returns the latest snapshot at or below height
+func (bc *Blockchain) snapshotAt(height int) *Snapshot {
+	for i := len(bc.snapshots) - 1; i >= 0; i-- {
+		if bc.snapshots[i].Height <= height {
+			return &bc.snapshots[i]
+		}
+	}
+	return nil
+}
+
+// snapshotFor returns the snapshot taken at exactly the given block
+func (bc *Blockchain) snapshotFor(block Block) *Snapshot {
+	for i := range bc.snapshots {
+		if bc.snapshots[i].Height == block.Index && bc.snapshots[i].BlockHash == block.Hash {
+			return &bc.snapshots[i]
+		}
+	}
+	return nil
+}
+
+// saveSnapshot writes a snapshot atomically. Pruned history cannot be
+// replayed without its snapshot, so a torn file would cost the whole chain.
+func (bc *Blockchain) saveSnapshot(snapshot Snapshot) error {
+	dir := filepath.Join(bc.dataDir, snapshotDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot directory: %v", err)
+	}
+	return storage.SaveData(snapshot, snapshotFileName(snapshot.Height), dir)
+}
+
+// loadSnapshots reads every valid snapshot on disk ordered by height
+func (bc *Blockchain) loadSnapshots() []Snapshot {
+	dir := filepath.Join(bc.dataDir, snapshotDir)
+	fileNames, err := storage.ListFiles(dir)
+	if err != nil {
+		return nil
+	}
+
+	var snapshots []Snapshot
+	for _, fileName := range fileNames {
+		var height int
+		if _, err := fmt.Sscanf(fileName, "snapshot_%d.json", &height); err != nil || filepath.Ext(fileName) != ".json" {
+			continue
+		}
+		snapshot, err := LoadSnapshotFile(filepath.Join(dir, fileName))
+		if err != nil {
+			log.Printf("Ignoring snapshot %s: %v", fileName, err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Height < snapshots[j].Height })
+	return snapshots
+}
+
+func snapshotFileName(height int) string {
+	return fmt.Sprintf("snapshot_%d.json", height)
+}
+
+func hashState(state *State) (string, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode state: %v", err)
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
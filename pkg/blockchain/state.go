@@ -0,0 +1,139 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrBadNonce is returned when a transaction does not carry the sender's next nonce
+	ErrBadNonce = errors.New("unexpected transaction nonce")
+	// ErrConflictingResult is returned when a result disagrees with the one
+	// already registered for the same algorithm and dataset
+	ErrConflictingResult = errors.New("result conflicts with registered task result")
+	// ErrInsufficientBalance is returned when a sender cannot pay the fee
+	ErrInsufficientBalance = errors.New("balance does not cover the fee")
+	// ErrBadCoinbase is returned for a misplaced coinbase or one claiming too much
+	ErrBadCoinbase = errors.New("invalid coinbase transaction")
+)
+
+// State is the ledger state produced by replaying the chain
+type State struct {
+	Nonces   map[string]uint64 `json:"nonces"`             // sender -> next expected nonce
+	Tasks    map[string]string `json:"tasks"`              // algorithm|dataset -> centroid hash
+	Balances map[string]uint64 `json:"balances,omitempty"` // address -> spendable amount
+}
+
+// NewState returns the empty state before the genesis block
+func NewState() *State {
+	return &State{
+		Nonces:   make(map[string]uint64),
+		Tasks:    make(map[string]string),
+		Balances: make(map[string]uint64),
+	}
+}
+
+// Clone returns a deep copy of the state
+func (s *State) Clone() *State {
+	clone := NewState()
+	for sender, nonce := range s.Nonces {
+		clone.Nonces[sender] = nonce
+	}
+	for task, result := range s.Tasks {
+		clone.Tasks[task] = result
+	}
+	for address, balance := range s.Balances {
+		clone.Balances[address] = balance
+	}
+	return clone
+}
+
+// NextNonce returns the nonce the sender's next transaction must use
+func (s *State) NextNonce(sender string) uint64 {
+	return s.Nonces[sender]
+}
+
+// Balance returns the amount held by address
+func (s *State) Balance(address string) uint64 {
+	return s.Balances[address]
+}
+
+// CheckTransaction verifies that tx is a valid transition from the current
+// state. Coinbase transactions are only valid as part of a block.
+func (s *State) CheckTransaction(tx Transaction) error {
+	if tx.IsCoinbase() {
+		return fmt.Errorf("%w: coinbase outside of a block", ErrBadCoinbase)
+	}
+	if tx.Nonce != s.Nonces[tx.Sender] {
+		return fmt.Errorf("%w: got %d, expected %d", ErrBadNonce, tx.Nonce, s.Nonces[tx.Sender])
+	}
+	if tx.Fee > s.Balances[tx.Sender] {
+		return fmt.Errorf("%w: fee %d, balance %d", ErrInsufficientBalance, tx.Fee, s.Balances[tx.Sender])
+	}
+	return s.CheckResult(tx)
+}
+
+// CheckResult verifies that tx agrees with any result already registered for its task
+func (s *State) CheckResult(tx Transaction) error {
+	if result, exists := s.Tasks[taskKey(tx)]; exists && result != tx.CentroidHash {
+		return ErrConflictingResult
+	}
+	return nil
+}
+
+// ApplyTransaction checks tx and applies it to the state
+func (s *State) ApplyTransaction(tx Transaction) error {
+	if err := s.CheckTransaction(tx); err != nil {
+		return err
+	}
+	s.applyUnchecked(tx)
+	return nil
+}
+
+// ApplyBlock applies every transaction in block. An optional coinbase must
+// come first and may claim at most BlockReward plus the block's fees; it is
+// credited after the other transactions. The state is left partially updated
+// on error, so callers should apply blocks to a clone.
+func (s *State) ApplyBlock(block Block) error {
+	var coinbase *Transaction
+	for i, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			if i != 0 {
+				return fmt.Errorf("transaction %d (%s): %w: coinbase must be first", i, tx.ID(), ErrBadCoinbase)
+			}
+			coinbase = &block.Transactions[0]
+			continue
+		}
+		if err := s.ApplyTransaction(tx); err != nil {
+			return fmt.Errorf("transaction %d (%s): %w", i, tx.ID(), err)
+		}
+	}
+	if coinbase != nil {
+		if limit := BlockReward + BlockFees(block.Transactions); coinbase.Amount > limit {
+			return fmt.Errorf("%w: claims %d, limit %d", ErrBadCoinbase, coinbase.Amount, limit)
+		}
+		s.applyUnchecked(*coinbase)
+	}
+	return nil
+}
+
+func (s *State) applyUnchecked(tx Transaction) {
+	if tx.IsCoinbase() {
+		s.Balances[tx.Recipient] += tx.Amount
+		return
+	}
+	s.Nonces[tx.Sender] = tx.Nonce + 1
+	s.Tasks[taskKey(tx)] = tx.CentroidHash
+	if tx.Fee > s.Balances[tx.Sender] {
+		s.Balances[tx.Sender] = 0
+	} else {
+		s.Balances[tx.Sender] -= tx.Fee
+	}
+	if s.Balances[tx.Sender] == 0 {
+		delete(s.Balances, tx.Sender)
+	}
+}
+
+func taskKey(tx Transaction) string {
+	return tx.AlgorithmHash + "|" + tx.DatasetHash
+}
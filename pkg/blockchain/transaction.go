@@ -1,8 +1,10 @@
 package blockchain
 
 import (
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 )
 
@@ -10,9 +12,15 @@ type Transaction struct {
 	AlgorithmHash string
 	DatasetHash   string
 	CentroidHash  string
+	Sender        string // hex-encoded ed25519 public key of the submitter
+	Nonce         uint64 // number of earlier transactions from Sender
+	Fee           uint64 // paid to the miner; higher fees are mined first
+	Recipient     string `json:",omitempty"` // coinbase only: address credited with Amount
+	Amount        uint64 `json:",omitempty"` // coinbase only: block reward plus fees
+	Signature     string // hex-encoded signature over the transaction content
 }
 
-func NewTransaction(algorithmData, datasetData , centroidData string) Transaction {
+func NewTransaction(algorithmData, datasetData, centroidData string) Transaction {
 	return Transaction{
 		AlgorithmHash: calculateHash(algorithmData),
 		DatasetHash:   calculateHash(datasetData),
@@ -29,7 +37,54 @@ func formatCentroid(centroid []float64) string {
 	return fmt.Sprintf("%v", centroid)
 }
 
+// GetSender returns the sender address
+func (t Transaction) GetSender() string {
+	return t.Sender
+}
+
+// GetNonce returns the sender nonce
+func (t Transaction) GetNonce() uint64 {
+	return t.Nonce
+}
+
+// GetFee returns the fee offered by the sender
+func (t Transaction) GetFee() uint64 {
+	return t.Fee
+}
+
+// Sign sets the sender and nonce and signs the transaction with key
+func (t *Transaction) Sign(key ed25519.PrivateKey, nonce uint64) {
+	t.Sender = AddressFromKey(key)
+	t.Nonce = nonce
+	t.Signature = hex.EncodeToString(ed25519.Sign(key, []byte(t.signingData())))
+}
+
+// VerifySignature checks that the transaction was signed by its sender
+func (t Transaction) VerifySignature() error {
+	publicKey, err := hex.DecodeString(t.Sender)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return errors.New("invalid sender address")
+	}
+	signature, err := hex.DecodeString(t.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return errors.New("invalid signature encoding")
+	}
+	if !ed25519.Verify(publicKey, []byte(t.signingData()), signature) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+// ID returns the transaction identifier, the hash of its signed content
+func (t Transaction) ID() string {
+	return calculateHash(t.signingData())
+}
+
+func (t Transaction) signingData() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%d|%d|%s|%d", t.AlgorithmHash, t.DatasetHash, t.CentroidHash, t.Sender, t.Nonce, t.Fee, t.Recipient, t.Amount)
+}
+
 func (t Transaction) Serialize() string {
-	return fmt.Sprintf("AlgorithmHash: %s, DatasetHash: %s, CentroidHash: %s",
-		t.AlgorithmHash, t.DatasetHash, t.CentroidHash)
+	return fmt.Sprintf("AlgorithmHash: %s, DatasetHash: %s, CentroidHash: %s, Sender: %s, Nonce: %d, Fee: %d, Recipient: %s, Amount: %d, Signature: %s",
+		t.AlgorithmHash, t.DatasetHash, t.CentroidHash, t.Sender, t.Nonce, t.Fee, t.Recipient, t.Amount, t.Signature)
 }
@@ -0,0 +1,262 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+// ValidationLevel selects how thoroughly the chain is checked
+type ValidationLevel int
+
+const (
+	// ValidateQuick recomputes header hashes and checks linkage, heights, proof
+	// of work and checkpoints
+	ValidateQuick ValidationLevel = iota
+	// ValidateFull additionally checks timestamps and replays and re-verifies
+	// Merkle roots, signatures and state transitions
+	ValidateFull
+)
+
+func (l ValidationLevel) String() string {
+	switch l {
+	case ValidateQuick:
+		return "quick"
+	case ValidateFull:
+		return "full"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// ParseValidationLevel converts "quick" or "full" into a ValidationLevel
+func ParseValidationLevel(name string) (ValidationLevel, error) {
+	switch name {
+	case "quick":
+		return ValidateQuick, nil
+	case "full":
+		return ValidateFull, nil
+	default:
+		return 0, fmt.Errorf("unknown validation level %q", name)
+	}
+}
+
+// BadBlock describes the first block that failed validation
+type BadBlock struct {
+	Height int
+	Hash   string
+	Reason string
+}
+
+// ValidationReport summarizes a chain validation run
+type ValidationReport struct {
+	Level         ValidationLevel
+	BlocksChecked int
+	FirstBad      *BadBlock // nil when every block is valid
+}
+
+// Valid reports whether no bad block was found
+func (r ValidationReport) Valid() bool {
+	return r.FirstBad == nil
+}
+
+func (r ValidationReport) String() string {
+	if r.FirstBad == nil {
+		return fmt.Sprintf("%s validation passed: %d blocks checked", r.Level, r.BlocksChecked)
+	}
+	return fmt.Sprintf("%s validation failed at height %d (%s): %s",
+		r.Level, r.FirstBad.Height, r.FirstBad.Hash, r.FirstBad.Reason)
+}
+
+// Verify validates the in-memory chain at the given level
+func (bc *Blockchain) Verify(level ValidationLevel) ValidationReport {
+	bc.chainMutex.Lock()
+	defer bc.chainMutex.Unlock()
+
+	report, _ := bc.verifyBlocks(bc.blocks, level)
+	return report
+}
+
+// VerifyStoredChain validates the chain stored in dataDir at the given level
+// without changing it: unlike OpenBlockchain, invalid blocks are only
+// reported, and neither legacy files nor the transaction index are touched.
+// The data directory must not be in use.
+func VerifyStoredChain(dataDir string, level ValidationLevel) (ValidationReport, error) {
+	bc, report, err := loadStoredChain(dataDir, level)
+	if err != nil {
+		return ValidationReport{}, err
+	}
+	bc.Close()
+	return report, nil
+}
+
+// OpenStoredChain loads the chain stored in dataDir for reading, such as an
+// export. Like VerifyStoredChain it changes nothing on disk, and it fails if
+// any block is invalid instead of discarding it. The returned chain has no
+// transaction index and must not be modified.
+func OpenStoredChain(dataDir string, level ValidationLevel) (*Blockchain, error) {
+	bc, report, err := loadStoredChain(dataDir, level)
+	if err != nil {
+		return nil, err
+	}
+	if len(bc.blocks) == 0 {
+		bc.Close()
+		return nil, errors.New("data directory holds no blocks")
+	}
+	if !report.Valid() {
+		bc.Close()
+		return nil, fmt.Errorf("stored chain is invalid: %s; run chain verify --repair first", report)
+	}
+	return bc, nil
+}
+
+// loadStoredChain opens the block store in dataDir and validates its blocks
+// without writing anything. The returned chain holds the valid blocks and the
+// state after them; its store stays open until Close.
+func loadStoredChain(dataDir string, level ValidationLevel) (*Blockchain, ValidationReport, error) {
+	if _, err := os.Stat(dataDir); err != nil {
+		return nil, ValidationReport{}, fmt.Errorf("no stored chain: %v", err)
+	}
+	bc := newBlockchain(dataDir)
+	store, err := storage.OpenBlockStore(dataDir)
+	if err != nil {
+		return nil, ValidationReport{}, fmt.Errorf("failed to open block store: %w", err)
+	}
+	bc.store = store
+	bc.snapshots = bc.loadSnapshots()
+
+	blocks, err := bc.loadBlocks()
+	if err != nil {
+		store.Close()
+		return nil, ValidationReport{}, err
+	}
+	if len(blocks) == 0 {
+		return bc, ValidationReport{Level: level}, nil
+	}
+	report, state := bc.verifyBlocks(blocks, level)
+	if report.FirstBad != nil {
+		blocks = blocks[:report.FirstBad.Height]
+	}
+	bc.blocks = blocks
+	bc.state = state
+	for _, block := range blocks {
+		if block.Pruned {
+			bc.prunedHeight = block.Index
+		}
+	}
+	return bc, report, nil
+}
+
+// verifyBlocks validates blocks from genesis and returns the report together
+// with the state after the last valid block. Pruned blocks are checked as
+// headers only; the state is restored from the snapshot taken at the last
+// pruned block.
+func (bc *Blockchain) verifyBlocks(blocks []Block, level ValidationLevel) (ValidationReport, *State) {
+	report := ValidationReport{Level: level}
+	state := NewState()
+	replayed := true // state reflects every block verified so far
+
+	for i, block := range blocks {
+		// A snapshot of a replayed block is compared with the state after it,
+		// which must then be rolled back if they disagree
+		snapshot := bc.snapshotFor(block)
+		var before *State
+		if snapshot != nil && replayed && !block.Pruned && level == ValidateFull {
+			before = state.Clone()
+		}
+
+		var err error
+		if i == 0 {
+			if block.Hash != GenesisBlock().Hash {
+				err = errors.New("unexpected genesis block")
+			}
+		} else if !replayed && !block.Pruned {
+			err = errors.New("pruned history has no matching state snapshot")
+		} else {
+			err = bc.verifyBlock(block, blocks[i-1], state, level)
+		}
+		if err != nil {
+			report.FirstBad = &BadBlock{Height: i, Hash: block.Hash, Reason: err.Error()}
+			return report, state
+		}
+		if block.Pruned {
+			replayed = false
+		}
+
+		if snapshot != nil {
+			if !replayed {
+				state = snapshot.State.Clone()
+				replayed = true
+			} else if before != nil {
+				if hash, _ := hashState(state); hash != snapshot.StateHash {
+					report.FirstBad = &BadBlock{Height: i, Hash: block.Hash, Reason: "replayed state does not match the snapshot"}
+					return report, before
+				}
+			}
+		}
+		report.BlocksChecked++
+	}
+	if !replayed {
+		// The chain ends in pruned headers with nothing to restore the state from
+		tip := blocks[len(blocks)-1]
+		report.BlocksChecked--
+		report.FirstBad = &BadBlock{Height: tip.Index, Hash: tip.Hash, Reason: "pruned history has no matching state snapshot"}
+	}
+
+	return report, state
+}
+
+// verifyBlock checks block against its parent and applies it to state
+func (bc *Blockchain) verifyBlock(block Block, parent Block, state *State, level ValidationLevel) error {
+	if block.Index != parent.Index+1 {
+		return fmt.Errorf("height %d does not follow %d", block.Index, parent.Index)
+	}
+	if block.PrevHash != parent.Hash {
+		return errors.New("previous hash does not match parent")
+	}
+	if block.Hash != block.CalculateHash() {
+		return errors.New("hash does not match header")
+	}
+	if !block.MeetsDifficulty(bc.difficulty) {
+		return fmt.Errorf("proof of work does not meet difficulty %d", bc.difficulty)
+	}
+	if err := bc.checkCheckpointLocked(block.Index, block.Hash); err != nil {
+		return err
+	}
+
+	if level == ValidateQuick {
+		for _, tx := range block.Transactions {
+			state.applyUnchecked(tx)
+		}
+		return nil
+	}
+
+	if block.Timestamp < parent.Timestamp {
+		return errors.New("timestamp is older than parent")
+	}
+	if block.Pruned {
+		return nil
+	}
+	if err := verifyBody(block); err != nil {
+		return err
+	}
+	return state.ApplyBlock(block)
+}
+
+// verifyBody checks the Merkle root and transaction signatures of a block
+func verifyBody(block Block) error {
+	if block.MerkleRoot != ComputeMerkleRoot(block.Transactions) {
+		return errors.New("merkle root does not match transactions")
+	}
+	for i, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		if err := tx.VerifySignature(); err != nil {
+			return fmt.Errorf("transaction %d (%s): %v", i, tx.ID(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,569 @@
+package chainsync
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+	"github.com/Wraitheon/blockchain-assignment/pkg/consensus"
+	"github.com/Wraitheon/blockchain-assignment/pkg/networking"
+)
+
+const (
+	// HeadersTimeout is how long a peer has to answer getheaders
+	HeadersTimeout = 30 * time.Second
+	// BlockTimeout is how long a peer has to deliver a requested block
+	BlockTimeout = 30 * time.Second
+	// MaxBlockRetries is how often a block is requested before sync restarts
+	MaxBlockRetries = 3
+	// MaxBlocksInFlight bounds outstanding block requests per peer
+	MaxBlocksInFlight = 16
+	// DownloadWindow bounds how far ahead of the chain tip bodies are fetched
+	DownloadWindow = 1024
+	// TickInterval is how often Run checks timeouts and schedules requests
+	TickInterval = 500 * time.Millisecond
+)
+
+// State describes what the syncer is doing
+type State string
+
+const (
+	StateIdle    State = "idle"    // no peer is ahead of us
+	StateHeaders State = "headers" // downloading headers
+	StateBlocks  State = "blocks"  // downloading block bodies
+)
+
+// Progress reports how far the chain is from the best known peer
+type Progress struct {
+	State        State `json:"state"`
+	Height       int   `json:"height"`        // tip of the local chain
+	HeaderHeight int   `json:"header_height"` // last validated header
+	TargetHeight int   `json:"target_height"` // best height announced by peers
+	InFlight     int   `json:"in_flight"`     // outstanding block requests
+	Peers        int   `json:"peers"`
+}
+
+// blockRequest is an outstanding getdata for one block
+type blockRequest struct {
+	peer     string
+	deadline time.Time
+}
+
+// Syncer downloads the chain from peers headers-first: it fetches and
+// validates headers from the best peer, then fetches the bodies from every
+// suitable peer in parallel and connects them in order through consensus.
+type Syncer struct {
+	Consensus *consensus.Consensus
+	Network   *networking.PeerManager
+
+	mu              sync.Mutex
+	headers         []blockchain.Block          // validated headers not yet connected, in height order
+	headerIndex     map[string]blockchain.Block // the same headers by hash
+	headersPeer     string                      // peer answering the outstanding getheaders
+	headersDeadline time.Time
+	inFlight        map[string]blockRequest     // block hash -> outstanding request
+	attempts        map[string]int              // block hash -> requests made
+	received        map[string]blockchain.Block // bodies waiting for their parent
+	peerHeights     map[string]int              // best height known for each peer
+	failures        map[string]int              // timeouts and bad responses per peer
+}
+
+// NewSyncer creates a syncer feeding blocks from network into cons
+func NewSyncer(cons *consensus.Consensus, network *networking.PeerManager) *Syncer {
+	return &Syncer{
+		Consensus:   cons,
+		Network:     network,
+		headerIndex: make(map[string]blockchain.Block),
+		inFlight:    make(map[string]blockRequest),
+		attempts:    make(map[string]int),
+		received:    make(map[string]blockchain.Block),
+		peerHeights: make(map[string]int),
+		failures:    make(map[string]int),
+	}
+}
+
+// Run drives the sync until stop is closed
+func (s *Syncer) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Progress returns the current sync progress
+func (s *Syncer) Progress() Progress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	progress := Progress{
+		Height:       s.Consensus.Blockchain.Height(),
+		InFlight:     len(s.inFlight),
+		TargetHeight: s.targetHeightLocked(),
+		Peers:        len(s.Network.Peers()),
+	}
+	progress.HeaderHeight = s.headerTipLocked().Index
+	switch {
+	case s.headersPeer != "":
+		progress.State = StateHeaders
+	case len(s.headers) > 0:
+		progress.State = StateBlocks
+	default:
+		progress.State = StateIdle
+	}
+	return progress
+}
+
+// NotePeerHeight records that peer has a chain of at least height, for
+// example after it announced a new block
+func (s *Syncer) NotePeerHeight(peer string, height int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if height > s.peerHeights[peer] {
+		s.peerHeights[peer] = height
+	}
+}
+
+// HandleMessage processes sync messages and serves headers and blocks to
+// syncing peers. It reports whether the message was consumed; blocks that
+// were not requested by the syncer and getdata requests for transactions are
+// left to the caller.
+func (s *Syncer) HandleMessage(peer string, message networking.Message) bool {
+	switch message.Command {
+	case networking.CmdGetHeaders:
+		var request networking.GetHeaders
+		if err := message.Decode(&request); err != nil {
+			s.Network.Misbehaving(peer, networking.PayloadScore(err), err.Error())
+			return true
+		}
+		headers := s.Consensus.Blockchain.HeadersAfter(request.Locator, request.StopHash, networking.MaxHeaders)
+		if err := s.Network.Send(peer, networking.CmdHeaders, networking.Headers{Headers: headers}); err != nil {
+			log.Printf("Failed to send headers to %s: %v", peer, err)
+		}
+		return true
+
+	case networking.CmdGetData:
+		var request networking.GetData
+		if err := message.Decode(&request); err != nil {
+			s.Network.Misbehaving(peer, networking.PayloadScore(err), err.Error())
+			return true
+		}
+		s.serveBlocks(peer, request.Items)
+		return false
+
+	case networking.CmdHeaders:
+		var response networking.Headers
+		if err := message.Decode(&response); err != nil {
+			s.Network.Misbehaving(peer, networking.PayloadScore(err), err.Error())
+			return true
+		}
+		s.handleHeaders(peer, response.Headers)
+		return true
+
+	case networking.CmdBlock:
+		var block blockchain.Block
+		if err := message.Decode(&block); err != nil {
+			s.Network.Misbehaving(peer, networking.PayloadScore(err), err.Error())
+			return true
+		}
+		return s.handleBlock(peer, block)
+	}
+	return false
+}
+
+// serveBlocks answers the block entries of a getdata request. Pruned blocks
+// cannot be served; the requester times out and asks another peer.
+func (s *Syncer) serveBlocks(peer string, items []networking.InvItem) {
+	for _, item := range items {
+		if item.Type != networking.InvBlock {
+			continue
+		}
+		block, exists := s.Consensus.Blockchain.GetBlockByHash(item.Hash)
+		if !exists || block.Pruned {
+			continue
+		}
+		if err := s.Network.Send(peer, networking.CmdBlock, block); err != nil {
+			log.Printf("Failed to send block to %s: %v", peer, err)
+			return
+		}
+	}
+}
+
+func (s *Syncer) handleHeaders(peer string, headers []blockchain.Block) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if peer != s.headersPeer {
+		return // unsolicited or too late
+	}
+	s.headersPeer = ""
+
+	if err := s.appendHeadersLocked(headers); err != nil {
+		log.Printf("Invalid headers from %s: %v", peer, err)
+		s.failures[peer]++
+		switch {
+		case errors.Is(err, errBadWork):
+			s.Network.Misbehaving(peer, networking.ScoreBadWork, err.Error())
+		case errors.Is(err, errInvalidHeader):
+			s.Network.Misbehaving(peer, networking.ScoreInvalidBlock, err.Error())
+		}
+		return
+	}
+	tip := s.headerTipLocked().Index
+	if len(headers) == 0 {
+		// Nothing beyond what we have, whatever the peer announced earlier
+		if s.peerHeights[peer] > tip {
+			s.peerHeights[peer] = tip
+		}
+	} else {
+		if tip > s.peerHeights[peer] {
+			s.peerHeights[peer] = tip
+		}
+		log.Printf("Received %d headers from %s, header height %d", len(headers), peer, tip)
+	}
+	if len(headers) == networking.MaxHeaders {
+		s.requestHeadersLocked(peer)
+	} else if tip <= s.Consensus.Blockchain.Height() {
+		// A branch that ends at or below our tip would never win fork choice
+		s.truncateHeadersLocked(-1)
+	}
+	s.scheduleBlocksLocked()
+}
+
+// appendHeadersLocked validates headers as a continuation of the pending
+// header chain, or of the main chain where the peer's chain forks off
+func (s *Syncer) appendHeadersLocked(headers []blockchain.Block) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	bc := s.Consensus.Blockchain
+
+	parent, ok := s.pendingHeader(headers[0].PrevHash)
+	if !ok {
+		main, onMain := bc.GetBlockByHash(headers[0].PrevHash)
+		if !onMain {
+			return fmt.Errorf("first header %s does not connect", headers[0].Hash)
+		}
+		if main.Index < bc.FinalizedHeight() {
+			return fmt.Errorf("%w: fork at %d", blockchain.ErrFinalityViolation, main.Index)
+		}
+		// The peer's chain forks off here; drop pending headers that disagree
+		s.truncateHeadersLocked(-1)
+		parent = main
+	} else {
+		s.truncateHeadersLocked(parent.Index)
+	}
+
+	for _, header := range headers {
+		if err := validateHeader(header, parent, bc); err != nil {
+			return fmt.Errorf("header %d (%s): %w", header.Index, header.Hash, err)
+		}
+		parent = header
+	}
+	for _, header := range headers {
+		if _, known := bc.GetBlockByHash(header.Hash); !known {
+			s.headers = append(s.headers, header)
+			s.headerIndex[header.Hash] = header
+		}
+	}
+	return nil
+}
+
+var (
+	// errInvalidHeader marks headers that cannot belong to a valid chain
+	errInvalidHeader = errors.New("invalid header")
+	// errBadWork marks headers whose hash is wrong or misses the target
+	errBadWork = errors.New("bad proof of work")
+)
+
+// validateHeader checks everything about a block that does not need its body
+func validateHeader(header, parent blockchain.Block, bc *blockchain.Blockchain) error {
+	if header.Index != parent.Index+1 {
+		return fmt.Errorf("%w: height does not follow %d", errInvalidHeader, parent.Index)
+	}
+	if header.PrevHash != parent.Hash {
+		return fmt.Errorf("%w: previous hash does not match parent", errInvalidHeader)
+	}
+	if header.Timestamp < parent.Timestamp {
+		return fmt.Errorf("%w: timestamp is older than parent", errInvalidHeader)
+	}
+	if header.Hash != header.CalculateHash() {
+		return fmt.Errorf("%w: hash does not match header", errBadWork)
+	}
+	if !header.MeetsDifficulty(bc.Difficulty()) {
+		return fmt.Errorf("%w: does not meet difficulty %d", errBadWork, bc.Difficulty())
+	}
+	if err := bc.CheckCheckpoint(header.Index, header.Hash); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidHeader, err)
+	}
+	return nil
+}
+
+func (s *Syncer) handleBlock(peer string, block blockchain.Block) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	header, expected := s.pendingHeader(block.Hash)
+	if !expected {
+		return false
+	}
+	delete(s.inFlight, block.Hash)
+
+	if block.Hash != block.CalculateHash() || block.MerkleRoot != header.MerkleRoot ||
+		block.MerkleRoot != blockchain.ComputeMerkleRoot(block.Transactions) {
+		log.Printf("Block %s from %s does not match its header", block.Hash, peer)
+		s.failures[peer]++
+		s.Network.Misbehaving(peer, networking.ScoreInvalidBlock, "block does not match its header")
+		return true
+	}
+	block.Pruned = false
+	s.received[block.Hash] = block
+	s.connectReadyLocked(peer)
+	s.scheduleBlocksLocked()
+	return true
+}
+
+// connectReadyLocked connects received bodies in header order. Once every
+// pending header is connected the new tip is announced, so peers that
+// connected while we were behind learn that we can now serve them.
+func (s *Syncer) connectReadyLocked(peer string) {
+	bc := s.Consensus.Blockchain
+	defer func() {
+		if len(s.headers) == 0 {
+			blocks := bc.GetBlocks()
+			s.Consensus.BroadcastBlock(blocks[len(blocks)-1])
+		}
+	}()
+	for len(s.headers) > 0 {
+		next := s.headers[0]
+		block, ready := s.received[next.Hash]
+		if !ready {
+			return
+		}
+		delete(s.received, next.Hash)
+		delete(s.attempts, next.Hash)
+		delete(s.headerIndex, next.Hash)
+		s.headers = s.headers[1:]
+
+		if _, known := bc.GetBlockByHash(block.Hash); known {
+			continue
+		}
+		if !s.Consensus.VerifyAndAddBlock(block, peer) {
+			if _, known := bc.GetBlockByHash(block.Hash); !known {
+				log.Printf("Synced block %d (%s) was rejected, restarting sync", block.Index, block.Hash)
+				s.failures[peer]++
+				s.Network.Misbehaving(peer, networking.ScoreInvalidBlock, "invalid block")
+				s.resetLocked()
+				return
+			}
+		}
+	}
+}
+
+// tick expires requests and schedules new ones
+func (s *Syncer) tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	connected := make(map[string]bool)
+	for _, peer := range s.Network.Peers() {
+		connected[peer] = true
+		if _, known := s.peerHeights[peer]; !known {
+			if version, ok := s.Network.PeerVersion(peer); ok {
+				s.peerHeights[peer] = version.BestHeight
+			}
+		}
+	}
+	for peer := range s.peerHeights {
+		if !connected[peer] {
+			delete(s.peerHeights, peer)
+			delete(s.failures, peer)
+		}
+	}
+
+	if s.headersPeer != "" && (now.After(s.headersDeadline) || !connected[s.headersPeer]) {
+		log.Printf("Peer %s did not answer getheaders in time", s.headersPeer)
+		s.failures[s.headersPeer]++
+		s.headersPeer = ""
+	}
+	for hash, request := range s.inFlight {
+		if now.After(request.deadline) || !connected[request.peer] {
+			delete(s.inFlight, hash)
+			s.failures[request.peer]++
+			if s.attempts[hash] >= MaxBlockRetries {
+				log.Printf("Block %s could not be downloaded, restarting sync", hash)
+				s.resetLocked()
+				return
+			}
+		}
+	}
+
+	if s.headersPeer == "" {
+		if peer := s.bestPeerLocked(s.headerTipLocked().Index); peer != "" {
+			s.requestHeadersLocked(peer)
+		}
+	}
+	s.scheduleBlocksLocked()
+}
+
+func (s *Syncer) requestHeadersLocked(peer string) {
+	locator := s.Consensus.Blockchain.Locator()
+	if len(s.headers) > 0 {
+		locator = append([]string{s.headers[len(s.headers)-1].Hash}, locator...)
+	}
+	if len(locator) > networking.MaxLocatorHashes {
+		locator = locator[:networking.MaxLocatorHashes]
+	}
+	if err := s.Network.Send(peer, networking.CmdGetHeaders, networking.GetHeaders{Locator: locator}); err != nil {
+		log.Printf("Failed to request headers from %s: %v", peer, err)
+		s.failures[peer]++
+		return
+	}
+	s.headersPeer = peer
+	s.headersDeadline = time.Now().Add(HeadersTimeout)
+}
+
+// scheduleBlocksLocked requests missing bodies within the download window,
+// spreading them across peers that have them
+func (s *Syncer) scheduleBlocksLocked() {
+	if len(s.headers) == 0 {
+		return
+	}
+	load := make(map[string]int)
+	for _, request := range s.inFlight {
+		load[request.peer]++
+	}
+
+	requests := make(map[string][]networking.InvItem)
+	limit := s.Consensus.Blockchain.Height() + DownloadWindow
+	for _, header := range s.headers {
+		if header.Index > limit {
+			break
+		}
+		if _, done := s.received[header.Hash]; done {
+			continue
+		}
+		if _, pending := s.inFlight[header.Hash]; pending {
+			continue
+		}
+		peer := s.pickBlockPeerLocked(header.Index, load)
+		if peer == "" {
+			break
+		}
+		load[peer]++
+		s.attempts[header.Hash]++
+		s.inFlight[header.Hash] = blockRequest{peer: peer, deadline: time.Now().Add(BlockTimeout)}
+		requests[peer] = append(requests[peer], networking.InvItem{Type: networking.InvBlock, Hash: header.Hash})
+	}
+
+	for peer, items := range requests {
+		if err := s.Network.Send(peer, networking.CmdGetData, networking.GetData{Items: items}); err != nil {
+			log.Printf("Failed to request blocks from %s: %v", peer, err)
+			for _, item := range items {
+				delete(s.inFlight, item.Hash)
+			}
+			s.failures[peer]++
+		}
+	}
+}
+
+// pickBlockPeerLocked chooses the least loaded reliable peer serving full
+// blocks up to height
+func (s *Syncer) pickBlockPeerLocked(height int, load map[string]int) string {
+	var candidates []string
+	for peer, peerHeight := range s.peerHeights {
+		if peerHeight < height || load[peer] >= MaxBlocksInFlight {
+			continue
+		}
+		if version, ok := s.Network.PeerVersion(peer); !ok || version.Services&networking.ServiceFullBlocks == 0 {
+			continue
+		}
+		candidates = append(candidates, peer)
+	}
+	if len(candidates) == 0 {
+		return ""
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if s.failures[a] != s.failures[b] {
+			return s.failures[a] < s.failures[b]
+		}
+		if load[a] != load[b] {
+			return load[a] < load[b]
+		}
+		return a < b
+	})
+	return candidates[0]
+}
+
+// bestPeerLocked returns the most reliable peer claiming a chain above height
+func (s *Syncer) bestPeerLocked(height int) string {
+	best := ""
+	for peer, peerHeight := range s.peerHeights {
+		if peerHeight <= height {
+			continue
+		}
+		if best == "" || s.failures[peer] < s.failures[best] ||
+			(s.failures[peer] == s.failures[best] && peerHeight > s.peerHeights[best]) {
+			best = peer
+		}
+	}
+	return best
+}
+
+func (s *Syncer) targetHeightLocked() int {
+	target := s.Consensus.Blockchain.Height()
+	for _, height := range s.peerHeights {
+		if height > target {
+			target = height
+		}
+	}
+	return target
+}
+
+// headerTipLocked returns the last pending header, or the chain tip
+func (s *Syncer) headerTipLocked() blockchain.Block {
+	if len(s.headers) > 0 {
+		return s.headers[len(s.headers)-1]
+	}
+	blocks := s.Consensus.Blockchain.GetBlocks()
+	return blocks[len(blocks)-1]
+}
+
+func (s *Syncer) pendingHeader(hash string) (blockchain.Block, bool) {
+	header, exists := s.headerIndex[hash]
+	return header, exists
+}
+
+// truncateHeadersLocked drops pending headers above height
+func (s *Syncer) truncateHeadersLocked(height int) {
+	for i, header := range s.headers {
+		if header.Index > height {
+			for _, dropped := range s.headers[i:] {
+				delete(s.headerIndex, dropped.Hash)
+			}
+			s.headers = s.headers[:i]
+			return
+		}
+	}
+}
+
+// resetLocked abandons the current download; the next tick starts over
+func (s *Syncer) resetLocked() {
+	s.headers = nil
+	s.headerIndex = make(map[string]blockchain.Block)
+	s.headersPeer = ""
+	s.inFlight = make(map[string]blockRequest)
+	s.attempts = make(map[string]int)
+	s.received = make(map[string]blockchain.Block)
+}
@@ -0,0 +1,219 @@
+// Package config assembles the node configuration from defaults, a TOML,
+// YAML or JSON file, environment variables and command-line flags, each
+// overriding the one before.
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/networking"
+	"github.com/Wraitheon/blockchain-assignment/pkg/node"
+)
+
+// Config is the complete node configuration
+type Config struct {
+	DataDir string        `toml:"data_dir" yaml:"data_dir" json:"data_dir"`
+	Network NetworkConfig `toml:"network" yaml:"network" json:"network"`
+	Peers   PeersConfig   `toml:"peers" yaml:"peers" json:"peers"`
+	Chain   ChainConfig   `toml:"chain" yaml:"chain" json:"chain"`
+	IPFS    IPFSConfig    `toml:"ipfs" yaml:"ipfs" json:"ipfs"`
+	Mining  MiningConfig  `toml:"mining" yaml:"mining" json:"mining"`
+	API     APIConfig     `toml:"api" yaml:"api" json:"api"`
+	Log     LogConfig     `toml:"log" yaml:"log" json:"log"`
+}
+
+// NetworkConfig sets where the node accepts peers and how many
+type NetworkConfig struct {
+	Address     string `toml:"address" yaml:"address" json:"address"` // host to listen on
+	Port        int    `toml:"port" yaml:"port" json:"port"`
+	MaxInbound  int    `toml:"max_inbound" yaml:"max_inbound" json:"max_inbound"`
+	MaxOutbound int    `toml:"max_outbound" yaml:"max_outbound" json:"max_outbound"`
+}
+
+// PeersConfig sets which peers the node connects to
+type PeersConfig struct {
+	Seeds    []string `toml:"seeds" yaml:"seeds" json:"seeds"`       // bootstrap addresses
+	Connect  []string `toml:"connect" yaml:"connect" json:"connect"` // always kept connected
+	Outbound int      `toml:"outbound" yaml:"outbound" json:"outbound"`
+}
+
+// ChainConfig sets which blocks the node treats as final and how much
+// history it keeps
+type ChainConfig struct {
+	CheckpointFile   string `toml:"checkpoint_file" yaml:"checkpoint_file" json:"checkpoint_file"`       // signed checkpoint list
+	CheckpointPubKey string `toml:"checkpoint_pubkey" yaml:"checkpoint_pubkey" json:"checkpoint_pubkey"` // hex ed25519 key it is signed with
+	FinalityDepth    int    `toml:"finality_depth" yaml:"finality_depth" json:"finality_depth"`
+	PruneDepth       int    `toml:"prune_depth" yaml:"prune_depth" json:"prune_depth"` // 0 keeps every block body
+}
+
+// IPFSConfig sets where the algorithm and datasets are fetched from. Leaving
+// a CID empty skips creating the startup transaction.
+type IPFSConfig struct {
+	Gateway      string `toml:"gateway" yaml:"gateway" json:"gateway"`
+	TempDir      string `toml:"temp_dir" yaml:"temp_dir" json:"temp_dir"` // deleted after use
+	ConfigCID    string `toml:"config_cid" yaml:"config_cid" json:"config_cid"`
+	AlgorithmCID string `toml:"algorithm_cid" yaml:"algorithm_cid" json:"algorithm_cid"`
+	DatasetsCID  string `toml:"datasets_cid" yaml:"datasets_cid" json:"datasets_cid"`
+}
+
+// MiningConfig sets how often pending transactions are mined; 0 disables
+// mining
+type MiningConfig struct {
+	Interval Duration `toml:"interval" yaml:"interval" json:"interval"`
+}
+
+// APIConfig sets where the HTTP API is served; an empty address disables it
+type APIConfig struct {
+	Address string `toml:"address" yaml:"address" json:"address"`
+}
+
+// LogConfig sets where log output goes
+type LogConfig struct {
+	File       string `toml:"file" yaml:"file" json:"file"` // appended to; stderr if empty
+	Timestamps bool   `toml:"timestamps" yaml:"timestamps" json:"timestamps"`
+}
+
+// Duration is a time.Duration written as a string such as "30s" in files
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Default returns the configuration used when nothing is overridden
+func Default() Config {
+	defaults := node.DefaultConfig()
+	return Config{
+		DataDir: defaults.DataDir,
+		Network: NetworkConfig{
+			Address:     "0.0.0.0",
+			Port:        3000,
+			MaxInbound:  defaults.Limits.MaxInbound,
+			MaxOutbound: defaults.Limits.MaxOutbound,
+		},
+		Peers: PeersConfig{Seeds: []string{}, Connect: []string{}, Outbound: defaults.OutboundPeers},
+		Chain: ChainConfig{FinalityDepth: defaults.FinalityDepth},
+		IPFS: IPFSConfig{
+			Gateway:      defaults.IPFSGateway,
+			TempDir:      defaults.TempDir,
+			ConfigCID:    "QmPagXcseqBzKDFL2F4oEDYcuAkiixy28ZF3N3yfLSPUjJ",
+			AlgorithmCID: "QmQVpkvKaRPq8hzqG3NThTzKSsFBkkJW6FkJkHfu48ncMf",
+			DatasetsCID:  "QmZSWXRErHNeYFo7dt5LR28o8NgnWmXndfbmxr9R2bLN7W",
+		},
+		Log: LogConfig{Timestamps: true},
+	}
+}
+
+// ListenAddress returns the host:port the node accepts peers on
+func (c Config) ListenAddress() string {
+	return net.JoinHostPort(c.Network.Address, fmt.Sprint(c.Network.Port))
+}
+
+// Node returns the configuration for node.NewNodeWithConfig
+func (c Config) Node() node.Config {
+	config := node.DefaultConfig()
+	config.DataDir = c.DataDir
+	config.IPFSGateway = c.IPFS.Gateway
+	config.TempDir = c.IPFS.TempDir
+	config.ListenAddress = c.ListenAddress()
+	config.Seeds = c.Peers.Seeds
+	config.Connect = c.Peers.Connect
+	config.OutboundPeers = c.Peers.Outbound
+	config.Limits.MaxInbound = c.Network.MaxInbound
+	config.Limits.MaxOutbound = c.Network.MaxOutbound
+	config.APIAddress = c.API.Address
+	config.MineInterval = time.Duration(c.Mining.Interval)
+	config.CheckpointFile = c.Chain.CheckpointFile
+	config.CheckpointKey = c.Chain.CheckpointPubKey
+	config.FinalityDepth = c.Chain.FinalityDepth
+	config.PruneDepth = c.Chain.PruneDepth
+	return config
+}
+
+// Validate reports every setting that is out of range or malformed
+func (c Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.DataDir != "", "data_dir must be set")
+	check(c.Network.Address != "", "network.address must be set")
+	check(c.Network.Port > 0 && c.Network.Port <= 65535, "network.port %d is not between 1 and 65535", c.Network.Port)
+	check(c.Network.MaxInbound >= 0, "network.max_inbound must not be negative")
+	check(c.Network.MaxOutbound >= 0, "network.max_outbound must not be negative")
+	check(c.Peers.Outbound >= 0 && c.Peers.Outbound <= c.Network.MaxOutbound,
+		"peers.outbound %d is not between 0 and network.max_outbound %d", c.Peers.Outbound, c.Network.MaxOutbound)
+	for _, address := range c.Peers.Seeds {
+		if err := networking.ValidAddress(address); err != nil {
+			errs = append(errs, fmt.Errorf("peers.seeds: %v", err))
+		}
+	}
+	for _, address := range c.Peers.Connect {
+		if err := networking.ValidAddress(address); err != nil {
+			errs = append(errs, fmt.Errorf("peers.connect: %v", err))
+		}
+	}
+
+	check(c.Chain.CheckpointFile == "" || c.Chain.CheckpointPubKey != "",
+		"chain.checkpoint_pubkey must be set to verify chain.checkpoint_file")
+	key, err := hex.DecodeString(c.Chain.CheckpointPubKey)
+	check(err == nil && (len(key) == 0 || len(key) == ed25519.PublicKeySize),
+		"chain.checkpoint_pubkey is not a hex ed25519 public key")
+	check(c.Chain.FinalityDepth >= 0, "chain.finality_depth must not be negative")
+	check(c.Chain.PruneDepth >= 0, "chain.prune_depth must not be negative")
+	// Without finality a reorg could reach below the pruned bodies
+	check(c.Chain.PruneDepth == 0 || c.Chain.FinalityDepth > 0,
+		"chain.prune_depth needs chain.finality_depth to be set")
+
+	if gateway, err := url.Parse(c.IPFS.Gateway); err != nil || (gateway.Scheme != "http" && gateway.Scheme != "https") || gateway.Host == "" {
+		errs = append(errs, fmt.Errorf("ipfs.gateway %q is not an http or https URL", c.IPFS.Gateway))
+	}
+	check(c.IPFS.TempDir != "", "ipfs.temp_dir must be set")
+	// The temp directory is deleted after use, so it must not hold the chain
+	check(c.IPFS.TempDir == "" || c.DataDir == "" || !sameOrInside(c.DataDir, c.IPFS.TempDir),
+		"ipfs.temp_dir must not contain data_dir")
+
+	check(c.Mining.Interval >= 0, "mining.interval must not be negative")
+	if c.API.Address != "" {
+		if _, _, err := net.SplitHostPort(c.API.Address); err != nil {
+			errs = append(errs, fmt.Errorf("api.address: %v", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sameOrInside reports whether path is dir or lies inside it
+func sameOrInside(path, dir string) bool {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
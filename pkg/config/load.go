@@ -0,0 +1,250 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvPrefix starts the name of every environment variable that sets an
+	// option, e.g. BLOCKCHAIN_NETWORK_PORT for network.port
+	EnvPrefix = "BLOCKCHAIN_"
+	// EnvFile names the config file when --config is not given
+	EnvFile = EnvPrefix + "CONFIG"
+)
+
+// setting is one option that can be set from the environment and flags
+type setting struct {
+	key   string // dotted name as in the config file
+	flag  string
+	usage string
+	field func(c *Config) interface{} // pointer to the option in c
+}
+
+var settings = []setting{
+	{"data_dir", "datadir", "directory holding the chain, keys and peer data", func(c *Config) interface{} { return &c.DataDir }},
+	{"network.address", "address", "host to accept peer connections on", func(c *Config) interface{} { return &c.Network.Address }},
+	{"network.port", "port", "port to accept peer connections on", func(c *Config) interface{} { return &c.Network.Port }},
+	{"network.max_inbound", "max-inbound", "most inbound peer connections", func(c *Config) interface{} { return &c.Network.MaxInbound }},
+	{"network.max_outbound", "max-outbound", "most outbound peer connections", func(c *Config) interface{} { return &c.Network.MaxOutbound }},
+	{"peers.seeds", "seeds", "comma-separated bootstrap peers", func(c *Config) interface{} { return &c.Peers.Seeds }},
+	{"peers.connect", "connect", "comma-separated peers to keep connected, optionally as id@host:port", func(c *Config) interface{} { return &c.Peers.Connect }},
+	{"peers.outbound", "outbound", "outbound connections to maintain", func(c *Config) interface{} { return &c.Peers.Outbound }},
+	{"chain.checkpoint_file", "checkpoint-file", "signed checkpoint list to enforce", func(c *Config) interface{} { return &c.Chain.CheckpointFile }},
+	{"chain.checkpoint_pubkey", "checkpoint-pubkey", "hex ed25519 public key the checkpoint list is signed with", func(c *Config) interface{} { return &c.Chain.CheckpointPubKey }},
+	{"chain.finality_depth", "finality-depth", "confirmations after which reorgs are refused; 0 disables", func(c *Config) interface{} { return &c.Chain.FinalityDepth }},
+	{"chain.prune_depth", "prune-depth", "drop block bodies this many blocks below the tip, at least the finality depth, which must be set; 0 keeps all", func(c *Config) interface{} { return &c.Chain.PruneDepth }},
+	{"ipfs.gateway", "ipfs-gateway", "IPFS API URL", func(c *Config) interface{} { return &c.IPFS.Gateway }},
+	{"ipfs.temp_dir", "temp-dir", "scratch directory for IPFS downloads, deleted after use", func(c *Config) interface{} { return &c.IPFS.TempDir }},
+	{"ipfs.config_cid", "config-cid", "CID of the algorithm config", func(c *Config) interface{} { return &c.IPFS.ConfigCID }},
+	{"ipfs.algorithm_cid", "algorithm-cid", "CID of the algorithm", func(c *Config) interface{} { return &c.IPFS.AlgorithmCID }},
+	{"ipfs.datasets_cid", "datasets-cid", "CID of the dataset folder", func(c *Config) interface{} { return &c.IPFS.DatasetsCID }},
+	{"mining.interval", "mine", "mine pending transactions this often, e.g. 30s; 0 disables", func(c *Config) interface{} { return &c.Mining.Interval }},
+	{"api.address", "api", "host:port to serve the HTTP API on; empty disables", func(c *Config) interface{} { return &c.API.Address }},
+	{"log.file", "log-file", "file to append log output to instead of stderr", func(c *Config) interface{} { return &c.Log.File }},
+	{"log.timestamps", "log-timestamps", "prefix log lines with the date and time", func(c *Config) interface{} { return &c.Log.Timestamps }},
+}
+
+// envName returns the environment variable for s
+func (s setting) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(s.key, ".", "_"))
+}
+
+// set parses value into the option s points at in c
+func (s setting) set(c *Config, value string) error {
+	switch field := s.field(c).(type) {
+	case *string:
+		*field = value
+	case *int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*field = n
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+		*field = b
+	case *[]string:
+		*field = splitList(value)
+	case *Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration", value)
+		}
+		*field = Duration(d)
+	default:
+		return fmt.Errorf("unsupported type %T", field)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated value, ignoring empty entries
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Flags are the configuration flags registered on a flag set
+type Flags struct {
+	file   string
+	values map[string]string // flag values by setting key
+	order  []string          // setting keys in the order their flags were given
+}
+
+// RegisterFlags adds --config and a flag for every option to fs. After
+// fs.Parse, Load builds the configuration.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{values: make(map[string]string)}
+	fs.StringVar(&f.file, "config", "", "TOML, YAML or JSON config file (default $"+EnvFile+")")
+	for _, s := range settings {
+		s := s
+		record := func(value string) error {
+			if _, seen := f.values[s.key]; !seen {
+				f.order = append(f.order, s.key)
+			}
+			f.values[s.key] = value
+			return nil
+		}
+		usage := fmt.Sprintf("%s (%s, $%s)", s.usage, s.key, s.envName())
+		if _, isBool := s.field(&Config{}).(*bool); isBool {
+			fs.BoolFunc(s.flag, usage, record)
+		} else {
+			fs.Func(s.flag, usage, record)
+		}
+	}
+	return f
+}
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and the parsed flags, each overriding the one before, and
+// validates it
+func (f *Flags) Load() (Config, error) {
+	c := Default()
+
+	path := f.file
+	if path == "" {
+		path = os.Getenv(EnvFile)
+	}
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.envName()); ok {
+			if err := s.set(&c, value); err != nil {
+				return Config{}, fmt.Errorf("$%s: %v", s.envName(), err)
+			}
+		}
+	}
+
+	byKey := make(map[string]setting, len(settings))
+	for _, s := range settings {
+		byKey[s.key] = s
+	}
+	for _, key := range f.order {
+		s := byKey[key]
+		if err := s.set(&c, f.values[key]); err != nil {
+			return Config{}, fmt.Errorf("--%s: %v", s.flag, err)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return c, nil
+}
+
+// loadFile overrides c with the options in the file at path, whose format is
+// chosen by its extension. Unknown options are an error.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	switch format := formatOf(path); format {
+	case "toml":
+		meta, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("config file %s: %v", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, len(undecoded))
+			for i, key := range undecoded {
+				keys[i] = key.String()
+			}
+			sort.Strings(keys)
+			return fmt.Errorf("config file %s: unknown options %s", path, strings.Join(keys, ", "))
+		}
+	case "yaml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config file %s: %v", path, err)
+		}
+	case "json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(c); err != nil {
+			return fmt.Errorf("config file %s: %v", path, err)
+		}
+	default:
+		return fmt.Errorf("config file %s: unknown format, use .toml, .yaml, .yml or .json", path)
+	}
+	return nil
+}
+
+// formatOf returns the config format for a file name, or "" if unknown
+func formatOf(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		return "toml"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".json":
+		return "json"
+	}
+	return ""
+}
+
+// Write encodes c to w as toml, yaml or json
+func (c Config) Write(w io.Writer, format string) error {
+	switch format {
+	case "toml":
+		return toml.NewEncoder(w).Encode(c)
+	case "yaml":
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(c); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(c)
+	}
+	return fmt.Errorf("unknown format %q, use toml, yaml or json", format)
+}
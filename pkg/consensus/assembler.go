@@ -0,0 +1,105 @@
+package consensus
+
+import (
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+// DefaultMaxBlockSize limits the serialized size of a block's transactions
+const DefaultMaxBlockSize = 1 << 20
+
+// AssemblerConfig bounds the blocks built by a BlockAssembler
+type AssemblerConfig struct {
+	MaxBlockSize int // bytes of serialized transactions, including the coinbase
+	MaxBlockTxs  int // transactions, including the coinbase
+}
+
+// DefaultAssemblerConfig returns the default block limits
+func DefaultAssemblerConfig() AssemblerConfig {
+	return AssemblerConfig{
+		MaxBlockSize: DefaultMaxBlockSize,
+		MaxBlockTxs:  MaxBlockTransactions,
+	}
+}
+
+// BlockTemplate is an unmined block ready for proof-of-work. Miners vary the
+// nonce until the block hash meets Difficulty.
+type BlockTemplate struct {
+	Block      blockchain.Block `json:"block"`
+	Difficulty int              `json:"difficulty"`
+	Fees       uint64           `json:"fees"`
+	Reward     uint64           `json:"reward"`
+	Size       int              `json:"size"`
+}
+
+// BlockAssembler builds block templates from the mempool
+type BlockAssembler struct {
+	Blockchain *blockchain.Blockchain
+	Mempool    *storage.Mempool
+	Config     AssemblerConfig
+}
+
+// NewBlockAssembler creates an assembler with the given limits
+func NewBlockAssembler(bc *blockchain.Blockchain, mempool *storage.Mempool, config AssemblerConfig) *BlockAssembler {
+	return &BlockAssembler{
+		Blockchain: bc,
+		Mempool:    mempool,
+		Config:     config,
+	}
+}
+
+// NewTemplate builds a block on the current tip from the highest fee-rate
+// transactions that still apply, within the size and count limits. When
+// recipient is set, a coinbase paying it the block reward and fees comes first.
+func (a *BlockAssembler) NewTemplate(recipient string) BlockTemplate {
+	blocks := a.Blockchain.GetBlocks()
+	tip := blocks[len(blocks)-1]
+	height := tip.Index + 1
+
+	maxTxs, maxSize := a.Config.MaxBlockTxs, a.Config.MaxBlockSize
+	if recipient != "" {
+		// Reserve room for the coinbase; its amount is not known yet, so
+		// assume the largest encoding
+		reserved := blockchain.NewCoinbase(recipient, height, ^uint64(0))
+		maxTxs--
+		maxSize -= len(reserved.Serialize())
+	}
+
+	// Candidates arrive in priority order with each sender's nonces in
+	// sequence; anything that no longer applies is skipped
+	state := a.Blockchain.State()
+	var txs []blockchain.Transaction
+	size := 0
+	for _, pending := range a.Mempool.SelectTransactions(0, 0) {
+		if maxTxs > 0 && len(txs) >= maxTxs {
+			break
+		}
+		tx, ok := pending.(blockchain.Transaction)
+		if !ok {
+			continue
+		}
+		txSize := len(tx.Serialize())
+		if maxSize > 0 && size+txSize > maxSize {
+			continue
+		}
+		if state.ApplyTransaction(tx) != nil {
+			continue
+		}
+		txs = append(txs, tx)
+		size += txSize
+	}
+
+	template := BlockTemplate{
+		Difficulty: a.Blockchain.Difficulty(),
+		Fees:       blockchain.BlockFees(txs),
+	}
+	if recipient != "" {
+		template.Reward = blockchain.BlockReward + template.Fees
+		coinbase := blockchain.NewCoinbase(recipient, height, template.Reward)
+		txs = append([]blockchain.Transaction{coinbase}, txs...)
+		size += len(coinbase.Serialize())
+	}
+	template.Block = blockchain.NewBlock(height, txs, tip.Hash)
+	template.Size = size
+	return template
+}
@@ -1,34 +1,43 @@
 package consensus
 
 import (
-	"crypto/sha256"
 	"errors"
 
 	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
 )
 
-// ValidateTransaction verifies the integrity of a transaction
-func ValidateTransaction(tx blockchain.Transaction) bool {
-	// In this case, we'll just check if the centroid is non-empty
-	return len(tx.Centroid) > 0
+// ValidateTransaction performs the checks that need no chain state: every
+// hash is present and the signature matches the sender
+func ValidateTransaction(tx blockchain.Transaction) error {
+	if tx.IsCoinbase() {
+		return storage.Reject(storage.RejectMalformed, "coinbase transactions are only valid in blocks")
+	}
+	if tx.AlgorithmHash == "" || tx.DatasetHash == "" || tx.CentroidHash == "" {
+		return storage.Reject(storage.RejectMalformed, "missing algorithm, dataset or centroid hash")
+	}
+	if tx.Sender == "" || tx.Signature == "" {
+		return storage.Reject(storage.RejectMalformed, "transaction is not signed")
+	}
+	if err := tx.VerifySignature(); err != nil {
+		return storage.Reject(storage.RejectInvalid, "%v", err)
+	}
+	return nil
 }
 
-// ValidateBlock ensures a block meets all criteria before adding to the blockchain
+// ValidateBlock checks a block's header against its parent. The body is
+// verified by Blockchain.AppendBlock.
 func ValidateBlock(block blockchain.Block, prevBlock blockchain.Block, difficulty int) error {
 	if block.PrevHash != prevBlock.Hash {
 		return errors.New("invalid previous hash")
 	}
 
-	pow := NewProofOfWork([]byte(block.Hash), difficulty)
-	if !pow.Validate(block.Nonce) {
-		return errors.New("invalid proof of work")
+	if block.Hash != block.CalculateHash() {
+		return errors.New("invalid block hash")
 	}
 
-	for _, txStr := range block.Transactions {
-		hash := sha256.Sum256([]byte(txStr))
-		if hash == [32]byte{} {
-			return errors.New("invalid transaction hash")
-		}
+	if !block.MeetsDifficulty(difficulty) {
+		return errors.New("invalid proof of work")
 	}
 
 	return nil
@@ -0,0 +1,180 @@
+package consensus
+
+import (
+	"errors"
+	"log"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+)
+
+const (
+	// MaxSideBlocks bounds the number of blocks kept on competing branches
+	MaxSideBlocks = 500
+	// MaxInvalidBlocks bounds the number of invalid block hashes remembered
+	MaxInvalidBlocks = 1000
+)
+
+// acceptBlock connects a block whose parent is known, either by extending the
+// tip or by tracking it on a side branch
+func (c *Consensus) acceptBlock(block blockchain.Block) bool {
+	blocks := c.Blockchain.GetBlocks()
+	if block.PrevHash == blocks[len(blocks)-1].Hash {
+		return c.connectBlock(block)
+	}
+	return c.acceptSideBlock(block)
+}
+
+// acceptSideBlock stores a block that does not extend the tip and switches to
+// its branch once the branch is longer than the main chain. Branches that fork
+// below the finalized height or conflict with a checkpoint are refused.
+func (c *Consensus) acceptSideBlock(block blockchain.Block) bool {
+	parent, known := c.lookupBlock(block.PrevHash)
+	if !known {
+		log.Printf("Side block %s has unknown parent %s", block.Hash, block.PrevHash)
+		return false
+	}
+	if err := ValidateBlock(block, parent, c.Difficulty); err != nil {
+		log.Println("Invalid side block:", err)
+		return false
+	}
+	if err := c.Blockchain.CheckCheckpoint(block.Index, block.Hash); err != nil {
+		log.Println("Rejected side block:", err)
+		return false
+	}
+
+	c.sideMutex.Lock()
+	defer c.sideMutex.Unlock()
+
+	// Walk back to the main chain to find the fork point
+	branch := []blockchain.Block{block}
+	forkHash := block.PrevHash
+	for {
+		side, exists := c.sideBlocks[forkHash]
+		if !exists {
+			break
+		}
+		branch = append([]blockchain.Block{side}, branch...)
+		forkHash = side.PrevHash
+	}
+	forkBlock, onMain := c.Blockchain.GetBlockByHash(forkHash)
+	if !onMain {
+		log.Printf("Side block %s does not connect to the main chain", block.Hash)
+		return false
+	}
+	if forkBlock.Index < c.Blockchain.FinalizedHeight() {
+		log.Printf("Rejected side block %s: %v", block.Hash, blockchain.ErrFinalityViolation)
+		return false
+	}
+
+	c.storeSideBlockLocked(block)
+	if block.Index <= c.Blockchain.Height() {
+		log.Printf("Stored side block %s at height %d", block.Hash, block.Index)
+		return true
+	}
+
+	disconnected, err := c.Blockchain.Reorganize(forkBlock.Index, branch)
+	if err != nil {
+		if errors.Is(err, blockchain.ErrFinalityViolation) || errors.Is(err, blockchain.ErrCheckpointMismatch) ||
+			errors.Is(err, blockchain.ErrPrunedFork) {
+			log.Println("Refused reorganization:", err)
+		} else {
+			log.Println("Failed to reorganize:", err)
+		}
+		c.dropBranchLocked(branch, err)
+		return false
+	}
+	for _, b := range branch {
+		delete(c.sideBlocks, b.Hash)
+	}
+	for _, b := range disconnected {
+		c.storeSideBlockLocked(b)
+	}
+	c.removeConfirmed(branch)
+	c.readmitTransactions(disconnected)
+	log.Printf("Reorganized chain at height %d, new tip %s", forkBlock.Index, block.Hash)
+	return true
+}
+
+// dropBranchLocked forgets a branch whose reorganization failed, so its
+// blocks are no longer reported as known. The block that failed validation and
+// its descendants are marked invalid. The caller must hold sideMutex.
+func (c *Consensus) dropBranchLocked(branch []blockchain.Block, err error) {
+	var blockErr *blockchain.BlockError
+	failed := errors.As(err, &blockErr)
+	bad := false
+	for _, b := range branch {
+		delete(c.sideBlocks, b.Hash)
+		if failed && b.Hash == blockErr.Hash {
+			bad = true
+		}
+		if bad {
+			c.markInvalidLocked(b.Hash)
+		}
+	}
+}
+
+// IsInvalid reports whether a block failed validation or descends from one
+func (c *Consensus) IsInvalid(hash string) bool {
+	c.sideMutex.Lock()
+	defer c.sideMutex.Unlock()
+	return c.invalid[hash]
+}
+
+func (c *Consensus) markInvalid(hash string) {
+	c.sideMutex.Lock()
+	defer c.sideMutex.Unlock()
+	c.markInvalidLocked(hash)
+}
+
+// markInvalidLocked remembers an invalid block, forgetting an arbitrary one
+// when the set is full. The caller must hold sideMutex.
+func (c *Consensus) markInvalidLocked(hash string) {
+	for old := range c.invalid {
+		if len(c.invalid) < MaxInvalidBlocks {
+			break
+		}
+		delete(c.invalid, old)
+	}
+	c.invalid[hash] = true
+}
+
+// lookupBlock finds a block on the main chain or a side branch
+func (c *Consensus) lookupBlock(hash string) (blockchain.Block, bool) {
+	if block, exists := c.Blockchain.GetBlockByHash(hash); exists {
+		return block, true
+	}
+	c.sideMutex.Lock()
+	defer c.sideMutex.Unlock()
+	block, exists := c.sideBlocks[hash]
+	return block, exists
+}
+
+// HasBlock reports whether a block is on the main chain, a side branch or
+// waiting in the orphan pool
+func (c *Consensus) HasBlock(hash string) bool {
+	if _, known := c.lookupBlock(hash); known {
+		return true
+	}
+	return c.Orphans.Has(hash)
+}
+
+// storeSideBlockLocked keeps a side block, dropping finalized and excess
+// branches. The caller must hold sideMutex.
+func (c *Consensus) storeSideBlockLocked(block blockchain.Block) {
+	finalized := c.Blockchain.FinalizedHeight()
+	for hash, b := range c.sideBlocks {
+		if b.Index <= finalized {
+			delete(c.sideBlocks, hash)
+		}
+	}
+	for len(c.sideBlocks) >= MaxSideBlocks {
+		var lowest string
+		for hash, b := range c.sideBlocks {
+			if lowest == "" || b.Index < c.sideBlocks[lowest].Index {
+				lowest = hash
+			}
+		}
+		delete(c.sideBlocks, lowest)
+	}
+	c.sideBlocks[block.Hash] = block
+}
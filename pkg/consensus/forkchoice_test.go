@@ -0,0 +1,91 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+)
+
+func TestSideBranchBelowFinalityRefused(t *testing.T) {
+	c := newTestConsensus(t)
+	c.Blockchain.SetFinalityDepth(1)
+	miner, other := newTestKey(t), newTestKey(t)
+	genesis, _ := c.Blockchain.GetBlockByHeight(0)
+	for i := 0; i < 3; i++ {
+		mineTip(t, c, blockchain.AddressFromKey(miner))
+	}
+
+	side := mineSide(c, genesis, blockchain.AddressFromKey(other))
+	if c.VerifyAndAddBlock(side, "") {
+		t.Fatalf("side block forking below the finalized height was accepted")
+	}
+	if c.HasBlock(side.Hash) {
+		t.Errorf("refused side block is reported as known")
+	}
+}
+
+func TestSideBranchConflictingWithCheckpointRefused(t *testing.T) {
+	c := newTestConsensus(t)
+	c.Blockchain.SetFinalityDepth(0)
+	miner, other := newTestKey(t), newTestKey(t)
+	genesis, _ := c.Blockchain.GetBlockByHeight(0)
+	pinned := mineTip(t, c, blockchain.AddressFromKey(miner))
+	if err := c.Blockchain.SetCheckpoints([]blockchain.Checkpoint{{Height: 1, Hash: pinned.Hash}}); err != nil {
+		t.Fatalf("SetCheckpoints: %v", err)
+	}
+
+	side := mineSide(c, genesis, blockchain.AddressFromKey(other))
+	if c.VerifyAndAddBlock(side, "") {
+		t.Fatalf("side block conflicting with a checkpoint was accepted")
+	}
+	if c.HasBlock(side.Hash) {
+		t.Errorf("refused side block is reported as known")
+	}
+	if tip, _ := c.Blockchain.GetBlockByHeight(1); tip.Hash != pinned.Hash {
+		t.Errorf("checkpointed block was replaced")
+	}
+}
+
+func TestFailedReorgDropsBranchAndMarksItInvalid(t *testing.T) {
+	c := newTestConsensus(t)
+	miner, other := newTestKey(t), newTestKey(t)
+	genesis, _ := c.Blockchain.GetBlockByHeight(0)
+	tip := mineTip(t, c, blockchain.AddressFromKey(miner))
+
+	// A valid side block, stored because it does not outgrow the main chain
+	valid := mineSide(c, genesis, blockchain.AddressFromKey(other))
+	if !c.VerifyAndAddBlock(valid, "") {
+		t.Fatalf("side block was not stored")
+	}
+
+	// Its child has valid work but claims too large a reward, which only the
+	// reorganization that replays its body finds
+	height := valid.Index + 1
+	coinbase := blockchain.NewCoinbase(blockchain.AddressFromKey(other), height, blockchain.BlockReward+1)
+	bad := blockchain.NewBlock(height, []blockchain.Transaction{coinbase}, valid.Hash)
+	bad.MineBlock(c.Difficulty)
+	if c.VerifyAndAddBlock(bad, "") {
+		t.Fatalf("block with an invalid body was accepted")
+	}
+
+	if current, _ := c.Blockchain.GetBlockByHeight(c.Blockchain.Height()); current.Hash != tip.Hash {
+		t.Errorf("tip changed to %s, want %s", current.Hash, tip.Hash)
+	}
+	if c.HasBlock(bad.Hash) || c.HasBlock(valid.Hash) {
+		t.Errorf("blocks of the failed branch are still known")
+	}
+	if !c.IsInvalid(bad.Hash) {
+		t.Errorf("block that failed validation is not marked invalid")
+	}
+	if c.IsInvalid(valid.Hash) {
+		t.Errorf("valid ancestor of the failed block was marked invalid")
+	}
+
+	child := mineSide(c, bad, blockchain.AddressFromKey(other))
+	if c.VerifyAndAddBlock(child, "") || !c.IsInvalid(child.Hash) {
+		t.Errorf("descendant of an invalid block was not refused as invalid")
+	}
+	if c.Orphans.Has(child.Hash) {
+		t.Errorf("descendant of an invalid block was kept as an orphan")
+	}
+}
@@ -0,0 +1,108 @@
+package consensus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+// MempoolSaveInterval is how often PersistMempool writes the mempool to disk
+const MempoolSaveInterval = time.Minute
+
+// removeConfirmed drops transactions included in newly connected blocks, then
+// any pending transaction the new tip makes invalid. A transaction whose nonce
+// is now used is removed alone, since the sender's next pending nonce may now
+// be the valid one; a result that conflicts with a registered one strands the
+// sender's later nonces, so they go with it.
+func (c *Consensus) removeConfirmed(connected []blockchain.Block) {
+	for _, block := range connected {
+		for _, tx := range block.Transactions {
+			c.Mempool.Remove(tx.ID())
+		}
+	}
+
+	state := c.Blockchain.State()
+	removed := 0
+	for _, pending := range c.Mempool.GetTransactions() {
+		tx, ok := pending.(blockchain.Transaction)
+		if !ok {
+			continue
+		}
+		switch {
+		case tx.Nonce < state.NextNonce(tx.Sender):
+			if c.Mempool.Remove(tx.ID()) {
+				removed++
+			}
+		case state.CheckResult(tx) != nil:
+			removed += c.Mempool.RemoveWithDescendants(tx.ID())
+		}
+	}
+	if removed > 0 {
+		log.Printf("Removed %d conflicting transactions from the mempool", removed)
+	}
+}
+
+// readmitTransactions returns transactions from blocks disconnected by a
+// reorganization to the mempool if they are still valid on the new chain
+func (c *Consensus) readmitTransactions(disconnected []blockchain.Block) {
+	readmitted := 0
+	for _, block := range disconnected {
+		for _, tx := range block.Transactions {
+			if c.Mempool.Has(tx.ID()) {
+				continue
+			}
+			if err := c.admitTransaction(tx, time.Now()); err == nil {
+				readmitted++
+			}
+		}
+	}
+	if readmitted > 0 {
+		log.Printf("Returned %d transactions from disconnected blocks to the mempool", readmitted)
+	}
+}
+
+// LoadMempool restores the mempool saved in dataDir. Each transaction is
+// revalidated against the current tip and dropped if it no longer applies or
+// has expired. It returns how many transactions were restored.
+func (c *Consensus) LoadMempool(dataDir string) (int, error) {
+	saved, err := storage.LoadSavedMempool(dataDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load mempool: %v", err)
+	}
+	restored := 0
+	for _, entry := range saved {
+		var tx blockchain.Transaction
+		if err := json.Unmarshal(entry.Tx, &tx); err != nil {
+			log.Printf("Skipping unreadable saved transaction: %v", err)
+			continue
+		}
+		if err := c.admitTransaction(tx, entry.Added); err != nil {
+			continue
+		}
+		restored++
+	}
+	if len(saved) > 0 {
+		log.Printf("Restored %d of %d saved mempool transactions", restored, len(saved))
+	}
+	return restored, nil
+}
+
+// PersistMempool saves the mempool to dataDir every interval until stop is closed
+func (c *Consensus) PersistMempool(dataDir string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Mempool.Save(dataDir); err != nil {
+				log.Printf("Failed to save mempool: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package consensus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+)
+
+const (
+	// MaxOrphanBlocks bounds the number of orphan blocks kept in memory
+	MaxOrphanBlocks = 100
+	// OrphanExpiry is how long an orphan waits for its parent before being dropped
+	OrphanExpiry = 20 * time.Minute
+)
+
+type orphanBlock struct {
+	block    blockchain.Block
+	from     string // peer that sent the block
+	received time.Time
+}
+
+// OrphanPool holds blocks that arrived before their parent, keyed by parent hash
+type OrphanPool struct {
+	mu       sync.Mutex
+	orphans  map[string]*orphanBlock // block hash -> orphan
+	byParent map[string][]string     // parent hash -> orphan block hashes
+	maxSize  int
+	expiry   time.Duration
+}
+
+// NewOrphanPool creates an orphan pool with the given size and age limits
+func NewOrphanPool(maxSize int, expiry time.Duration) *OrphanPool {
+	return &OrphanPool{
+		orphans:  make(map[string]*orphanBlock),
+		byParent: make(map[string][]string),
+		maxSize:  maxSize,
+		expiry:   expiry,
+	}
+}
+
+// Add stores an orphan block received from the given peer. It returns false
+// if the block is already in the pool.
+func (op *OrphanPool) Add(block blockchain.Block, from string) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	if _, exists := op.orphans[block.Hash]; exists {
+		return false
+	}
+
+	op.expireLocked(time.Now())
+	for len(op.orphans) >= op.maxSize && op.maxSize > 0 {
+		op.evictOldestLocked()
+	}
+
+	op.orphans[block.Hash] = &orphanBlock{block: block, from: from, received: time.Now()}
+	op.byParent[block.PrevHash] = append(op.byParent[block.PrevHash], block.Hash)
+	return true
+}
+
+// Has reports whether a block with the given hash is waiting in the pool
+func (op *OrphanPool) Has(hash string) bool {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	_, exists := op.orphans[hash]
+	return exists
+}
+
+// Root walks up the orphan chain starting at hash and returns the hash of the
+// missing ancestor that needs to be requested.
+func (op *OrphanPool) Root(hash string) string {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	for {
+		orphan, exists := op.orphans[hash]
+		if !exists {
+			return hash
+		}
+		hash = orphan.block.PrevHash
+	}
+}
+
+// TakeChildren removes and returns the orphans whose parent is parentHash
+func (op *OrphanPool) TakeChildren(parentHash string) []blockchain.Block {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+
+	var children []blockchain.Block
+	for _, hash := range op.byParent[parentHash] {
+		if orphan, exists := op.orphans[hash]; exists {
+			children = append(children, orphan.block)
+			delete(op.orphans, hash)
+		}
+	}
+	delete(op.byParent, parentHash)
+	return children
+}
+
+// Len returns the number of orphans in the pool
+func (op *OrphanPool) Len() int {
+	op.mu.Lock()
+	defer op.mu.Unlock()
+	return len(op.orphans)
+}
+
+func (op *OrphanPool) expireLocked(now time.Time) {
+	for hash, orphan := range op.orphans {
+		if now.Sub(orphan.received) > op.expiry {
+			op.removeLocked(hash)
+		}
+	}
+}
+
+func (op *OrphanPool) evictOldestLocked() {
+	var oldest string
+	var oldestTime time.Time
+	for hash, orphan := range op.orphans {
+		if oldest == "" || orphan.received.Before(oldestTime) {
+			oldest, oldestTime = hash, orphan.received
+		}
+	}
+	if oldest != "" {
+		op.removeLocked(oldest)
+	}
+}
+
+func (op *OrphanPool) removeLocked(hash string) {
+	orphan, exists := op.orphans[hash]
+	if !exists {
+		return
+	}
+	delete(op.orphans, hash)
+
+	siblings := op.byParent[orphan.block.PrevHash]
+	for i, h := range siblings {
+		if h == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(op.byParent, orphan.block.PrevHash)
+	} else {
+		op.byParent[orphan.block.PrevHash] = siblings
+	}
+}
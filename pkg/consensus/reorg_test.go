@@ -0,0 +1,168 @@
+package consensus
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+func newTestConsensus(t *testing.T) *Consensus {
+	t.Helper()
+	bc, err := blockchain.NewBlockchain(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewBlockchain: %v", err)
+	}
+	t.Cleanup(func() { bc.Close() })
+	return NewConsensus(bc, storage.NewMempool(), bc.Difficulty(), nil)
+}
+
+func newTestKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signedTx returns a transaction from key with a result unique to data
+func signedTx(key ed25519.PrivateKey, nonce, fee uint64, data string) blockchain.Transaction {
+	tx := blockchain.NewTransaction("algorithm "+data, "dataset "+data, "centroid "+data)
+	tx.Fee = fee
+	tx.Sign(key, nonce)
+	return tx
+}
+
+// mineTip mines the node's block template paying recipient and connects it
+func mineTip(t *testing.T, c *Consensus, recipient string) blockchain.Block {
+	t.Helper()
+	block := c.Assembler.NewTemplate(recipient).Block
+	block.MineBlock(c.Difficulty)
+	if !c.VerifyAndAddBlock(block, "") {
+		t.Fatalf("block %d was not accepted", block.Index)
+	}
+	return block
+}
+
+// mineSide mines a block on parent paying recipient the reward and fees of txs
+func mineSide(c *Consensus, parent blockchain.Block, recipient string, txs ...blockchain.Transaction) blockchain.Block {
+	height := parent.Index + 1
+	coinbase := blockchain.NewCoinbase(recipient, height, blockchain.BlockReward+blockchain.BlockFees(txs))
+	block := blockchain.NewBlock(height, append([]blockchain.Transaction{coinbase}, txs...), parent.Hash)
+	block.MineBlock(c.Difficulty)
+	return block
+}
+
+// forkAbove builds a branch of length blocks on fork paying recipient and
+// connects it, which reorganizes the chain once it is longer than the tip
+func forkAbove(t *testing.T, c *Consensus, fork blockchain.Block, recipient string, length int, first ...blockchain.Transaction) blockchain.Block {
+	t.Helper()
+	parent := fork
+	for i := 0; i < length; i++ {
+		var txs []blockchain.Transaction
+		if i == 0 {
+			txs = first
+		}
+		block := mineSide(c, parent, recipient, txs...)
+		if !c.VerifyAndAddBlock(block, "") {
+			t.Fatalf("side block %d was not accepted", block.Index)
+		}
+		parent = block
+	}
+	return parent
+}
+
+func TestReorgReadmitsDisconnectedTransactions(t *testing.T) {
+	c := newTestConsensus(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+	fork := mineTip(t, c, blockchain.AddressFromKey(alice))
+
+	tx := signedTx(alice, 0, 1, "readmit")
+	if err := c.VerifyAndAddTransaction(tx); err != nil {
+		t.Fatalf("VerifyAndAddTransaction: %v", err)
+	}
+	mined := mineTip(t, c, blockchain.AddressFromKey(alice))
+	if len(mined.Transactions) != 2 {
+		t.Fatalf("mined block has %d transactions, want the coinbase and %s", len(mined.Transactions), tx.ID())
+	}
+	if c.Mempool.Has(tx.ID()) {
+		t.Fatalf("mined transaction is still pending")
+	}
+
+	tip := forkAbove(t, c, fork, blockchain.AddressFromKey(bob), 2)
+	if c.Blockchain.Height() != tip.Index {
+		t.Fatalf("height = %d, want the branch tip %d", c.Blockchain.Height(), tip.Index)
+	}
+	if !c.Mempool.Has(tx.ID()) {
+		t.Errorf("transaction from the disconnected block was not returned to the mempool")
+	}
+}
+
+func TestReorgSkipsTransactionsOnNewBranch(t *testing.T) {
+	c := newTestConsensus(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+	fork := mineTip(t, c, blockchain.AddressFromKey(alice))
+
+	tx := signedTx(alice, 0, 1, "both branches")
+	if err := c.VerifyAndAddTransaction(tx); err != nil {
+		t.Fatalf("VerifyAndAddTransaction: %v", err)
+	}
+	mineTip(t, c, blockchain.AddressFromKey(alice))
+
+	// The new branch confirms the same transaction
+	forkAbove(t, c, fork, blockchain.AddressFromKey(bob), 2, tx)
+	if c.Mempool.Has(tx.ID()) {
+		t.Errorf("transaction confirmed on the new branch was returned to the mempool")
+	}
+}
+
+func TestReorgSkipsTransactionsInvalidOnNewBranch(t *testing.T) {
+	c := newTestConsensus(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+	fork := mineTip(t, c, blockchain.AddressFromKey(alice))
+
+	tx := signedTx(alice, 0, 1, "first")
+	if err := c.VerifyAndAddTransaction(tx); err != nil {
+		t.Fatalf("VerifyAndAddTransaction: %v", err)
+	}
+	mineTip(t, c, blockchain.AddressFromKey(alice))
+
+	// The new branch uses alice's nonce 0 for a different transaction
+	other := signedTx(alice, 0, 1, "second")
+	forkAbove(t, c, fork, blockchain.AddressFromKey(bob), 2, other)
+	if c.Mempool.Has(tx.ID()) {
+		t.Errorf("transaction whose nonce was used on the new branch was returned to the mempool")
+	}
+	if c.Mempool.Has(other.ID()) {
+		t.Errorf("transaction confirmed on the new branch is pending")
+	}
+}
+
+func TestConnectKeepsNextNonceAfterCompetingTransaction(t *testing.T) {
+	c := newTestConsensus(t)
+	alice, bob := newTestKey(t), newTestKey(t)
+	tip := mineTip(t, c, blockchain.AddressFromKey(alice))
+
+	pending := signedTx(alice, 0, 1, "pending")
+	next := signedTx(alice, 1, 1, "next")
+	for _, tx := range []blockchain.Transaction{pending, next} {
+		if err := c.VerifyAndAddTransaction(tx); err != nil {
+			t.Fatalf("VerifyAndAddTransaction: %v", err)
+		}
+	}
+
+	// A block confirms a different transaction with alice's nonce 0
+	competing := signedTx(alice, 0, 1, "competing")
+	if !c.VerifyAndAddBlock(mineSide(c, tip, blockchain.AddressFromKey(bob), competing), "") {
+		t.Fatalf("block with the competing transaction was not accepted")
+	}
+	if c.Mempool.Has(pending.ID()) {
+		t.Errorf("transaction whose nonce was used is still pending")
+	}
+	if !c.Mempool.Has(next.ID()) {
+		t.Errorf("transaction with the next valid nonce was removed")
+	}
+}
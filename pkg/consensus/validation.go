@@ -1,53 +1,98 @@
 package consensus
 
 import (
+	"fmt"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
 	"github.com/Wraitheon/blockchain-assignment/pkg/networking"
 	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
 )
 
+// MaxBlockTransactions is the default limit on transactions in a mined block
+const MaxBlockTransactions = 1000
+
 type Consensus struct {
 	Blockchain *blockchain.Blockchain
 	Mempool    *storage.Mempool
+	Orphans    *OrphanPool
 	Difficulty int
-	Peers      []string // Connected peer addresses
+	Assembler  *BlockAssembler
+	Coinbase   string                  // address paid for blocks mined by this node
+	Network    *networking.PeerManager // connected peers; nil when running offline
 	Mutex      sync.Mutex
+
+	sideBlocks map[string]blockchain.Block // blocks on competing branches
+	invalid    map[string]bool             // blocks that failed validation and their descendants
+	sideMutex  sync.Mutex
 }
 
 // NewConsensus initializes the consensus module
-func NewConsensus(bc *blockchain.Blockchain, mempool *storage.Mempool, difficulty int, peers []string) *Consensus {
+func NewConsensus(bc *blockchain.Blockchain, mempool *storage.Mempool, difficulty int, network *networking.PeerManager) *Consensus {
 	return &Consensus{
 		Blockchain: bc,
 		Mempool:    mempool,
+		Orphans:    NewOrphanPool(MaxOrphanBlocks, OrphanExpiry),
+		Assembler:  NewBlockAssembler(bc, mempool, DefaultAssemblerConfig()),
 		Difficulty: difficulty,
-		Peers:      peers,
+		Network:    network,
+		sideBlocks: make(map[string]blockchain.Block),
+		invalid:    make(map[string]bool),
 	}
 }
 
-// BroadcastTransaction sends a transaction to all peers
+// BroadcastTransaction announces a transaction to peers that do not have it
 func (c *Consensus) BroadcastTransaction(tx blockchain.Transaction) {
-	for _, peer := range c.Peers {
-		go func(peer string) {
-			err := networking.SendTransaction(peer, tx)
-			if err != nil {
-				log.Printf("Failed to send transaction to %s: %v", peer, err)
-			}
-		}(peer)
+	if c.Network != nil {
+		c.Network.Announce(networking.InvItem{Type: networking.InvTx, Hash: tx.ID()})
 	}
 }
 
-// VerifyAndAddTransaction validates and adds a transaction to the mempool
-func (c *Consensus) VerifyAndAddTransaction(tx blockchain.Transaction) bool {
-	if ValidateTransaction(tx) {
-		c.Mempool.AddTransaction(tx)
-		log.Println("Transaction added to mempool")
-		return true
+// VerifyAndAddTransaction validates a transaction and adds it to the mempool.
+// Rejections are returned as *storage.RejectError so they can be reported to
+// the peer that sent the transaction.
+func (c *Consensus) VerifyAndAddTransaction(tx blockchain.Transaction) error {
+	if err := c.admitTransaction(tx, time.Now()); err != nil {
+		log.Printf("Transaction %s rejected: %v", tx.ID(), err)
+		return err
+	}
+	log.Println("Transaction added to mempool")
+	return nil
+}
+
+// admitTransaction runs every admission check and adds tx to the mempool as
+// if it had been received at added
+func (c *Consensus) admitTransaction(tx blockchain.Transaction, added time.Time) error {
+	if err := ValidateTransaction(tx); err != nil {
+		return err
+	}
+	if err := c.checkTransactionState(tx); err != nil {
+		return err
+	}
+	return c.Mempool.RestoreTransaction(tx, added)
+}
+
+// checkTransactionState verifies tx against the chain tip and the sender's
+// pending transactions. The nonce may replace a pending transaction or
+// directly follow the last one, but may not leave a gap.
+func (c *Consensus) checkTransactionState(tx blockchain.Transaction) error {
+	state := c.Blockchain.State()
+	confirmed := state.NextNonce(tx.Sender)
+	if tx.Nonce < confirmed {
+		return storage.Reject(storage.RejectNonce, "nonce %d already used, next is %d", tx.Nonce, confirmed)
+	}
+	if next := c.Mempool.NextNonce(tx.Sender, confirmed); tx.Nonce > next {
+		return storage.Reject(storage.RejectNonce, "nonce %d leaves a gap, next is %d", tx.Nonce, next)
+	}
+	if tx.Fee > state.Balance(tx.Sender) {
+		return storage.Reject(storage.RejectBalance, "fee %d exceeds balance %d", tx.Fee, state.Balance(tx.Sender))
+	}
+	if err := state.CheckResult(tx); err != nil {
+		return storage.Reject(storage.RejectConflict, "%v", err)
 	}
-	log.Println("Invalid transaction")
-	return false
+	return nil
 }
 
 // MineBlock mines a block with transactions from the mempool
@@ -55,54 +100,125 @@ func (c *Consensus) MineBlock() {
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
 
-	txs := c.Mempool.GetTransactions()
-	if len(txs) == 0 {
+	template := c.Assembler.NewTemplate(c.Coinbase)
+	pending := len(template.Block.Transactions)
+	if c.Coinbase != "" {
+		pending-- // the coinbase
+	}
+	if pending == 0 {
 		log.Println("No transactions to mine")
 		return
 	}
 
-	// Convert transactions to string representation
-	var txStrs []string
-	for _, tx := range txs {
-		txStrs = append(txStrs, tx.Serialize())
-	}
-
-	// Create a new block
-	lastBlock := c.Blockchain.GetBlocks()[len(c.Blockchain.GetBlocks())-1]
-	newBlock := blockchain.NewBlock(len(c.Blockchain.GetBlocks()), txStrs, lastBlock.Hash)
-
 	// Solve proof-of-work
-	pow := NewProofOfWork([]byte(newBlock.Hash), c.Difficulty)
-	nonce, _ := pow.Run()
-	newBlock.Nonce = nonce
-	newBlock.Hash = newBlock.CalculateHash()
+	newBlock := template.Block
+	newBlock.MineBlock(template.Difficulty)
 
-	// Broadcast mined block
+	// Connect the block locally before broadcasting it unchanged
+	if err := c.Blockchain.AppendBlock(newBlock); err != nil {
+		log.Println("Failed to add mined block:", err)
+		return
+	}
+	c.removeConfirmed([]blockchain.Block{newBlock})
 	c.BroadcastBlock(newBlock)
 }
 
-// BroadcastBlock sends a mined block to all peers
+// SubmitBlock connects a block mined from a template by an external miner and
+// relays it to peers
+func (c *Consensus) SubmitBlock(block blockchain.Block) error {
+	if !c.VerifyAndAddBlock(block, "") {
+		return fmt.Errorf("block %s was not accepted", block.Hash)
+	}
+	c.BroadcastBlock(block)
+	return nil
+}
+
+// BroadcastBlock announces a block to peers that do not have it; they fetch
+// the body with getdata
 func (c *Consensus) BroadcastBlock(block blockchain.Block) {
-	for _, peer := range c.Peers {
-		go func(peer string) {
-			err := networking.SendBlock(peer, block)
-			if err != nil {
-				log.Printf("Failed to send block to %s: %v", peer, err)
-			}
-		}(peer)
+	if c.Network != nil {
+		c.Network.Announce(networking.InvItem{Type: networking.InvBlock, Hash: block.Hash})
+	}
+}
+
+// VerifyAndAddBlock validates and adds a block received from a peer to the
+// blockchain. Blocks whose parent is unknown are kept in the orphan pool and
+// the missing parent is requested from the sending peer. Blocks that failed
+// validation during a reorganization, and their descendants, are refused.
+func (c *Consensus) VerifyAndAddBlock(block blockchain.Block, from string) bool {
+	if _, known := c.lookupBlock(block.Hash); known || c.Orphans.Has(block.Hash) {
+		log.Printf("Block %s already known", block.Hash)
+		return false
+	}
+	if c.IsInvalid(block.Hash) || c.IsInvalid(block.PrevHash) {
+		c.markInvalid(block.Hash)
+		log.Printf("Block %s is or builds on an invalid block", block.Hash)
+		return false
+	}
+
+	if _, known := c.lookupBlock(block.PrevHash); !known {
+		c.addOrphan(block, from)
+		return false
 	}
+
+	if !c.acceptBlock(block) {
+		return false
+	}
+	c.connectOrphans(block.Hash)
+	return true
 }
 
-// VerifyAndAddBlock validates and adds a block to the blockchain
-func (c *Consensus) VerifyAndAddBlock(block blockchain.Block) bool {
-	lastBlock := c.Blockchain.GetBlocks()[len(c.Blockchain.GetBlocks())-1]
+// connectBlock validates a block against the current tip and appends it
+func (c *Consensus) connectBlock(block blockchain.Block) bool {
+	blocks := c.Blockchain.GetBlocks()
+	lastBlock := blocks[len(blocks)-1]
 	err := ValidateBlock(block, lastBlock, c.Difficulty)
 	if err != nil {
 		log.Println("Invalid block:", err)
 		return false
 	}
 
-	c.Blockchain.AddBlock(block.Transactions)
+	if err := c.Blockchain.AppendBlock(block); err != nil {
+		log.Println("Failed to add block:", err)
+		return false
+	}
+	c.removeConfirmed([]blockchain.Block{block})
 	log.Println("Block added to blockchain")
 	return true
 }
+
+// addOrphan stores a block whose parent is missing and asks the sending peer,
+// over its connection, for the earliest missing ancestor. The syncer serves
+// the getdata and the reply comes back through VerifyAndAddBlock.
+func (c *Consensus) addOrphan(block blockchain.Block, from string) {
+	if !c.Orphans.Add(block, from) {
+		return
+	}
+	missing := c.Orphans.Root(block.Hash)
+	log.Printf("Orphan block %s stored, missing ancestor %s", block.Hash, missing)
+
+	if from == "" || c.Network == nil {
+		return
+	}
+	go func() {
+		if err := c.Network.RequestBlock(from, missing); err != nil {
+			log.Printf("Failed to request block %s from %s: %v", missing, from, err)
+		}
+	}()
+}
+
+// connectOrphans connects every orphan that descends from parentHash,
+// walking down the orphan tree as each child is accepted
+func (c *Consensus) connectOrphans(parentHash string) {
+	queue := []string{parentHash}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		for _, child := range c.Orphans.TakeChildren(parent) {
+			if c.acceptBlock(child) {
+				queue = append(queue, child.Hash)
+			}
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package networking
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+const (
+	// AddressBookFile is the name of the saved address book in the data directory
+	AddressBookFile = "peers.json"
+	// MaxAddresses bounds the size of the address book
+	MaxAddresses = 2000
+	// ReconnectBaseDelay is the wait after the first failed connection attempt;
+	// it doubles with every further failure
+	ReconnectBaseDelay = 5 * time.Second
+	// MaxReconnectDelay caps the wait between connection attempts
+	MaxReconnectDelay = 10 * time.Minute
+	// MaxFailedAttempts is how often an address that never worked is tried
+	// before it is forgotten
+	MaxFailedAttempts = 8
+	// AddressHorizon is how long an address that never worked is shared
+	AddressHorizon = 7 * 24 * time.Hour
+)
+
+// KnownAddress is an entry in the address book
+type KnownAddress struct {
+	Address     string    `json:"address"`
+	Source      string    `json:"source"` // seed, manual or the peer that told us
+	LastSeen    time.Time `json:"last_seen"`
+	LastAttempt time.Time `json:"last_attempt,omitempty"`
+	LastSuccess time.Time `json:"last_success,omitempty"`
+	Failures    int       `json:"failures"`             // consecutive failed attempts
+	Persistent  bool      `json:"persistent,omitempty"` // seeds and manual peers are never forgotten
+}
+
+// ready reports whether the backoff after the last failure has passed
+func (ka *KnownAddress) ready(now time.Time) bool {
+	return ka.Failures == 0 || !now.Before(ka.LastAttempt.Add(RetryDelay(ka.Failures)))
+}
+
+// RetryDelay returns how long to wait before retrying an address after
+// failures consecutive failed attempts
+func RetryDelay(failures int) time.Duration {
+	if failures <= 0 {
+		return 0
+	}
+	delay := ReconnectBaseDelay
+	for i := 1; i < failures && delay < MaxReconnectDelay; i++ {
+		delay *= 2
+	}
+	if delay > MaxReconnectDelay {
+		delay = MaxReconnectDelay
+	}
+	return delay
+}
+
+// AddressManager is the address book of nodes we may connect to. It learns
+// addresses from seeds, the command line and addr messages, remembers which
+// ones worked and is saved in the data directory.
+type AddressManager struct {
+	dataDir   string
+	addresses map[string]*KnownAddress
+	mu        sync.Mutex
+	saveMu    sync.Mutex // serializes Save so the last snapshot taken is the one kept
+}
+
+// NewAddressManager loads the address book saved in dataDir, starting empty
+// if there is none
+func NewAddressManager(dataDir string) *AddressManager {
+	am := &AddressManager{
+		dataDir:   dataDir,
+		addresses: make(map[string]*KnownAddress),
+	}
+	if err := am.load(); err != nil {
+		fmt.Printf("Starting with an empty address book: %v\n", err)
+	}
+	return am
+}
+
+func (am *AddressManager) load() error {
+	if _, err := os.Stat(filepath.Join(am.dataDir, AddressBookFile)); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	var saved []KnownAddress
+	if err := storage.LoadData(AddressBookFile, am.dataDir, &saved); err != nil {
+		return err
+	}
+	for i := range saved {
+		if ValidAddress(saved[i].Address) == nil {
+			am.addresses[saved[i].Address] = &saved[i]
+		}
+	}
+	return nil
+}
+
+// Save writes the address book to the data directory, replacing the file
+// atomically. Concurrent saves run one at a time.
+func (am *AddressManager) Save() error {
+	am.saveMu.Lock()
+	defer am.saveMu.Unlock()
+	return storage.SaveData(am.Addresses(), AddressBookFile, am.dataDir)
+}
+
+// ValidAddress checks that address is a dialable host:port, optionally
+// pinned to a node ID as id@host:port
+func ValidAddress(address string) error {
+	id, hostPort := SplitPeerAddress(address)
+	if raw, err := hex.DecodeString(id); err != nil || (id != "" && len(raw) != NodeIDSize) {
+		return fmt.Errorf("address %s has an invalid node ID", address)
+	}
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("address %s has no host", address)
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return fmt.Errorf("address %s is unspecified", address)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+		return fmt.Errorf("address %s has an invalid port", address)
+	}
+	return nil
+}
+
+// Add records address learned from source and reports whether it was new
+func (am *AddressManager) Add(address, source string) bool {
+	return am.add(address, source, false)
+}
+
+// AddPersistent records a seed or manually configured address, which is
+// kept however often connecting to it fails
+func (am *AddressManager) AddPersistent(address, source string) bool {
+	return am.add(address, source, true)
+}
+
+func (am *AddressManager) add(address, source string, persistent bool) bool {
+	if ValidAddress(address) != nil {
+		return false
+	}
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	now := time.Now()
+	if known, exists := am.addresses[address]; exists {
+		known.LastSeen = now
+		known.Persistent = known.Persistent || persistent
+		return false
+	}
+	if len(am.addresses) >= MaxAddresses && !am.evictLocked() {
+		return false
+	}
+	am.addresses[address] = &KnownAddress{Address: address, Source: source, LastSeen: now, Persistent: persistent}
+	return true
+}
+
+// evictLocked drops the least useful forgettable address to make room
+func (am *AddressManager) evictLocked() bool {
+	var worst *KnownAddress
+	for _, known := range am.addresses {
+		if known.Persistent {
+			continue
+		}
+		if worst == nil || known.Failures > worst.Failures ||
+			(known.Failures == worst.Failures && known.LastSeen.Before(worst.LastSeen)) {
+			worst = known
+		}
+	}
+	if worst == nil {
+		return false
+	}
+	delete(am.addresses, worst.Address)
+	return true
+}
+
+// Attempt records that a connection to address is being made
+func (am *AddressManager) Attempt(address string) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if known, exists := am.addresses[address]; exists {
+		known.LastAttempt = time.Now()
+	}
+}
+
+// Good records a successful connection to address
+func (am *AddressManager) Good(address string) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if known, exists := am.addresses[address]; exists {
+		known.LastSuccess = time.Now()
+		known.LastSeen = known.LastSuccess
+		known.Failures = 0
+	}
+}
+
+// Failed records a failed connection attempt. Addresses that never worked
+// are forgotten after MaxFailedAttempts unless they are persistent.
+func (am *AddressManager) Failed(address string) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	known, exists := am.addresses[address]
+	if !exists {
+		return
+	}
+	known.Failures++
+	if !known.Persistent && known.LastSuccess.IsZero() && known.Failures >= MaxFailedAttempts {
+		delete(am.addresses, address)
+	}
+}
+
+// Remove forgets address, for example because it leads back to this node
+func (am *AddressManager) Remove(address string) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	delete(am.addresses, address)
+}
+
+// Ready reports whether address is known and its retry backoff has passed
+func (am *AddressManager) Ready(address string) bool {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	known, exists := am.addresses[address]
+	return exists && known.ready(time.Now())
+}
+
+// Candidates returns addresses whose retry backoff has passed, skipping
+// those in exclude. Addresses that worked before come first, then those with
+// fewer failures.
+func (am *AddressManager) Candidates(exclude map[string]bool) []string {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	now := time.Now()
+	var ready []*KnownAddress
+	for address, known := range am.addresses {
+		if !exclude[address] && known.ready(now) {
+			ready = append(ready, known)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool {
+		a, b := ready[i], ready[j]
+		if !a.LastSuccess.Equal(b.LastSuccess) {
+			return a.LastSuccess.After(b.LastSuccess)
+		}
+		if a.Failures != b.Failures {
+			return a.Failures < b.Failures
+		}
+		return a.Address < b.Address
+	})
+	candidates := make([]string, len(ready))
+	for i, known := range ready {
+		candidates[i] = known.Address
+	}
+	return candidates
+}
+
+// Sample returns up to max random addresses worth sharing with peers: those
+// that worked before or were seen recently
+func (am *AddressManager) Sample(max int) []string {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	horizon := time.Now().Add(-AddressHorizon)
+	var shareable []string
+	for address, known := range am.addresses {
+		if !known.LastSuccess.IsZero() || known.LastSeen.After(horizon) {
+			shareable = append(shareable, address)
+		}
+	}
+	rand.Shuffle(len(shareable), func(i, j int) {
+		shareable[i], shareable[j] = shareable[j], shareable[i]
+	})
+	if len(shareable) > max {
+		shareable = shareable[:max]
+	}
+	return shareable
+}
+
+// Addresses returns a copy of the address book sorted by address
+func (am *AddressManager) Addresses() []KnownAddress {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	addresses := make([]KnownAddress, 0, len(am.addresses))
+	for _, known := range am.addresses {
+		addresses = append(addresses, *known)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].Address < addresses[j].Address })
+	return addresses
+}
+
+// Len returns the number of known addresses
+func (am *AddressManager) Len() int {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	return len(am.addresses)
+}
@@ -0,0 +1,133 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+// BanListFile is the name of the saved ban list in the data directory
+const BanListFile = "bans.json"
+
+// Ban keeps a host from connecting until it expires
+type Ban struct {
+	Host   string    `json:"host"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// BanList holds time-limited bans by host. It is saved whenever it changes so
+// bans survive restarts.
+type BanList struct {
+	dataDir string // empty keeps the list in memory only
+	bans    map[string]Ban
+	mu      sync.Mutex
+	saveMu  sync.Mutex // serializes save so the last snapshot taken is the one kept
+}
+
+// NewBanList loads the ban list saved in dataDir. An empty dataDir gives a
+// list that is never saved.
+func NewBanList(dataDir string) *BanList {
+	bl := &BanList{dataDir: dataDir, bans: make(map[string]Ban)}
+	if dataDir == "" {
+		return bl
+	}
+	if err := bl.load(); err != nil {
+		fmt.Printf("Starting with an empty ban list: %v\n", err)
+	}
+	return bl
+}
+
+func (bl *BanList) load() error {
+	if _, err := os.Stat(filepath.Join(bl.dataDir, BanListFile)); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	var saved []Ban
+	if err := storage.LoadData(BanListFile, bl.dataDir, &saved); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, ban := range saved {
+		if ban.Until.After(now) {
+			bl.bans[ban.Host] = ban
+		}
+	}
+	return nil
+}
+
+// Ban bans host for duration and saves the list
+func (bl *BanList) Ban(host string, duration time.Duration, reason string) error {
+	bl.mu.Lock()
+	bl.bans[host] = Ban{Host: host, Until: time.Now().Add(duration), Reason: reason}
+	bl.mu.Unlock()
+	return bl.save()
+}
+
+// Unban lifts the ban on host and saves the list
+func (bl *BanList) Unban(host string) error {
+	bl.mu.Lock()
+	_, banned := bl.bans[host]
+	delete(bl.bans, host)
+	bl.mu.Unlock()
+	if !banned {
+		return nil
+	}
+	return bl.save()
+}
+
+// IsBanned reports whether host is currently banned
+func (bl *BanList) IsBanned(host string) bool {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	ban, exists := bl.bans[host]
+	if !exists {
+		return false
+	}
+	if !ban.Until.After(time.Now()) {
+		delete(bl.bans, host)
+		return false
+	}
+	return true
+}
+
+// Bans returns the active bans sorted by host
+func (bl *BanList) Bans() []Ban {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+	now := time.Now()
+	bans := make([]Ban, 0, len(bl.bans))
+	for _, ban := range bl.bans {
+		if ban.Until.After(now) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].Host < bans[j].Host })
+	return bans
+}
+
+// save writes the list to the data directory, replacing the file atomically.
+// Bans from different peers' goroutines save one at a time.
+func (bl *BanList) save() error {
+	if bl.dataDir == "" {
+		return nil
+	}
+	bl.saveMu.Lock()
+	defer bl.saveMu.Unlock()
+	return storage.SaveData(bl.Bans(), BanListFile, bl.dataDir)
+}
+
+// hostOf returns the host part of a host:port address
+func hostOf(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return address
+	}
+	return host
+}
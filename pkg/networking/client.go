@@ -1,13 +1,16 @@
 package networking
 
 import (
-	"encoding/gob"
 	"fmt"
 	"net"
+	"time"
 )
 
+// DialTimeout bounds how long connecting to a peer may take
+const DialTimeout = 10 * time.Second
+
 func ConnectToPeer(address string) (net.Conn, error) {
-	conn, err := net.Dial("tcp", address)
+	conn, err := net.DialTimeout("tcp", address, DialTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to peer %s: %v", address, err)
 	}
@@ -15,11 +18,19 @@ func ConnectToPeer(address string) (net.Conn, error) {
 	return conn, nil
 }
 
-func SendMessage(conn net.Conn, message Message) error {
-	encoder := gob.NewEncoder(conn)
-	err := encoder.Encode(message)
-	if err != nil {
-		return fmt.Errorf("failed to send message: %v", err)
-	}
-	return nil
+// SendMessage frames payload under command and writes it to conn
+func SendMessage(conn net.Conn, command string, payload interface{}) error {
+	return WriteMessage(conn, command, payload)
+}
+
+// SendReject reports a refused transaction to the peer at address
+func (pm *PeerManager) SendReject(address string, reject Reject) error {
+	return pm.Send(address, CmdReject, reject)
+}
+
+// RequestBlock asks a connected peer for the block with the given hash. The
+// request goes over the peer's existing connection, never a new dial, since
+// an inbound peer's remote address is an ephemeral port.
+func (pm *PeerManager) RequestBlock(peer string, hash string) error {
+	return pm.Send(peer, CmdGetData, GetData{Items: []InvItem{{Type: InvBlock, Hash: hash}}})
 }
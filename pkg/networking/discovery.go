@@ -0,0 +1,258 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultOutboundPeers is how many peers discovery keeps connected
+	DefaultOutboundPeers = 8
+	// DiscoveryInterval is how often discovery checks its connections
+	DiscoveryInterval = 2 * time.Second
+	// AddressSaveInterval is how often the address book is written to disk
+	AddressSaveInterval = time.Minute
+
+	// Per-peer limit on addresses accepted from addr messages. The burst
+	// covers one full answer to getaddr.
+	AddrItemsPerSecond = 10
+	AddrItemsBurst     = MaxAddrItems
+)
+
+// DiscoveryConfig configures which peers discovery connects to
+type DiscoveryConfig struct {
+	Seeds         []string // bootstrap addresses, used when nothing better is known
+	Connect       []string // peers that are always kept connected
+	OutboundPeers int      // target number of outbound connections
+}
+
+// DefaultDiscoveryConfig returns a configuration without seeds or fixed peers
+func DefaultDiscoveryConfig() DiscoveryConfig {
+	return DiscoveryConfig{OutboundPeers: DefaultOutboundPeers}
+}
+
+// Discovery keeps the node connected: it dials addresses from the address
+// book until the outbound target is met, reconnects dropped peers with
+// exponential backoff and exchanges addresses with peers through
+// getaddr/addr.
+type Discovery struct {
+	Network   *PeerManager
+	Addresses *AddressManager
+	Config    DiscoveryConfig
+
+	mu         sync.Mutex
+	dialing    map[string]bool
+	answered   map[string]bool // peers whose getaddr was answered
+	addrLimits map[string]*RateLimiter
+}
+
+// NewDiscovery creates discovery for network, adding the configured seeds
+// and fixed peers to the address book
+func NewDiscovery(network *PeerManager, addresses *AddressManager, config DiscoveryConfig) *Discovery {
+	for _, seed := range config.Seeds {
+		addresses.AddPersistent(seed, "seed")
+	}
+	for _, address := range config.Connect {
+		addresses.AddPersistent(address, "manual")
+	}
+	return &Discovery{
+		Network:    network,
+		Addresses:  addresses,
+		Config:     config,
+		dialing:    make(map[string]bool),
+		answered:   make(map[string]bool),
+		addrLimits: make(map[string]*RateLimiter),
+	}
+}
+
+// Run maintains connections and saves the address book until stop is closed
+func (d *Discovery) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(DiscoveryInterval)
+	defer ticker.Stop()
+	saveTicker := time.NewTicker(AddressSaveInterval)
+	defer saveTicker.Stop()
+
+	d.tick()
+	for {
+		select {
+		case <-ticker.C:
+			d.tick()
+		case <-saveTicker.C:
+			if err := d.Addresses.Save(); err != nil {
+				fmt.Printf("Failed to save address book: %v\n", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// HandleMessage answers getaddr and learns from addr messages. It reports
+// whether the message was consumed.
+func (d *Discovery) HandleMessage(peer string, message Message) bool {
+	switch message.Command {
+	case CmdGetAddr:
+		d.mu.Lock()
+		answered := d.answered[peer]
+		d.answered[peer] = true
+		d.mu.Unlock()
+		if answered {
+			return true // once per connection, so peers cannot scrape the book
+		}
+		addresses := d.Addresses.Sample(MaxAddrItems)
+		if err := d.Network.Send(peer, CmdAddr, Addr{Addresses: addresses}); err != nil {
+			fmt.Printf("Failed to send addresses to %s: %v\n", peer, err)
+		}
+		return true
+
+	case CmdAddr:
+		var addr Addr
+		if err := message.Decode(&addr); err != nil {
+			d.Network.Misbehaving(peer, PayloadScore(err), err.Error())
+			return true
+		}
+		if !d.addrLimit(peer).Allow(len(addr.Addresses)) {
+			d.Network.Misbehaving(peer, ScoreSpam, "addr rate limit exceeded")
+			return true
+		}
+		learned := 0
+		for _, address := range addr.Addresses {
+			if d.Addresses.Add(address, peer) {
+				learned++
+			}
+		}
+		if learned > 0 {
+			fmt.Printf("Learned %d addresses from %s\n", learned, peer)
+		}
+		return true
+	}
+	return false
+}
+
+func (d *Discovery) addrLimit(peer string) *RateLimiter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	limit, exists := d.addrLimits[peer]
+	if !exists {
+		limit = NewRateLimiter(AddrItemsPerSecond, AddrItemsBurst)
+		d.addrLimits[peer] = limit
+	}
+	return limit
+}
+
+// tick records the listen addresses of connected peers, pinned to their node
+// IDs, and dials new peers until the outbound target is met
+func (d *Discovery) tick() {
+	connected := make(map[string]bool) // addresses in use, with and without IDs
+	ids := map[string]bool{d.Network.ID(): true}
+	if own := d.Network.local.ListenAddress; own != "" {
+		connected[own] = true
+	}
+	for _, info := range d.Network.PeerInfos() {
+		ids[info.ID] = true
+		connected[info.Address] = true
+		if info.Version.ListenAddress != "" {
+			listen := peerListenAddress(info.Address, info.Version.ListenAddress)
+			connected[listen] = true
+			d.Addresses.Add(JoinPeerAddress(info.ID, listen), info.ID)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for peer := range d.answered {
+		if !ids[peer] {
+			delete(d.answered, peer)
+		}
+	}
+	for peer := range d.addrLimits {
+		if !ids[peer] {
+			delete(d.addrLimits, peer)
+		}
+	}
+	// The same node may be known under several addresses, with and without
+	// its ID; claim both forms so it is dialed only once
+	claim := func(address string) {
+		id, hostPort := SplitPeerAddress(address)
+		connected[hostPort] = true
+		if id != "" {
+			ids[id] = true
+		}
+	}
+	inUse := func(address string) bool {
+		id, hostPort := SplitPeerAddress(address)
+		return connected[hostPort] || (id != "" && ids[id])
+	}
+	for address := range d.dialing {
+		claim(address)
+	}
+
+	for _, address := range d.Config.Connect {
+		if !inUse(address) && d.Addresses.Ready(address) {
+			d.dialLocked(address)
+			claim(address)
+		}
+	}
+	need := d.Config.OutboundPeers - len(d.Network.OutboundPeers()) - len(d.dialing)
+	for _, address := range d.Addresses.Candidates(nil) {
+		if need <= 0 {
+			break
+		}
+		if inUse(address) {
+			continue
+		}
+		d.dialLocked(address)
+		claim(address)
+		need--
+	}
+}
+
+// dialLocked connects to address in the background and asks the new peer
+// for more addresses
+func (d *Discovery) dialLocked(address string) {
+	d.dialing[address] = true
+	d.Addresses.Attempt(address)
+	go func() {
+		info, err := d.Network.ConnectPeer(address)
+		d.mu.Lock()
+		delete(d.dialing, address)
+		d.mu.Unlock()
+
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrSelfConnection):
+				d.Addresses.Remove(address)
+			case errors.Is(err, ErrTooManyPeers), errors.Is(err, ErrDuplicatePeer):
+				// Not the address's fault; try again later
+			default:
+				d.Addresses.Failed(address)
+			}
+			fmt.Printf("Failed to connect to %s: %v\n", address, err)
+			return
+		}
+		d.Addresses.Good(address)
+		if err := d.Network.Send(info.ID, CmdGetAddr, GetAddr{}); err != nil {
+			fmt.Printf("Failed to request addresses from %s: %v\n", address, err)
+		}
+	}()
+}
+
+// peerListenAddress resolves the listen address a peer announced, taking the
+// host from its connection when it announced an unspecified one
+func peerListenAddress(remote, listen string) string {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return listen
+	}
+	if ip := net.ParseIP(host); host != "" && (ip == nil || !ip.IsUnspecified()) {
+		return listen
+	}
+	remoteHost, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return listen
+	}
+	return net.JoinHostPort(remoteHost, port)
+}
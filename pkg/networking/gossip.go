@@ -0,0 +1,42 @@
+package networking
+
+import (
+	"fmt"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+// GossipBuffer is how many mempool events gossip may fall behind before the
+// mempool drops its subscription
+const GossipBuffer = 256
+
+// GossipTransactions announces every transaction added to the mempool to the
+// connected peers until stop is closed. If the mempool drops the subscription
+// after a burst, it resubscribes and announces the whole pool so that no
+// transaction added in the meantime is missed.
+func (pm *PeerManager) GossipTransactions(mempool *storage.Mempool, stop <-chan struct{}) {
+	events := mempool.Subscribe(GossipBuffer)
+	defer func() { mempool.Unsubscribe(events) }()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case event, ok := <-events:
+			if !ok {
+				fmt.Printf("Gossip fell behind the mempool, resubscribing\n")
+				events = mempool.Subscribe(GossipBuffer)
+				var items []InvItem
+				for _, tx := range mempool.GetTransactions() {
+					items = append(items, InvItem{Type: InvTx, Hash: tx.ID()})
+				}
+				pm.Announce(items...)
+				continue
+			}
+			if event.Type != storage.TxAdded {
+				continue
+			}
+			pm.Announce(InvItem{Type: InvTx, Hash: event.Tx.ID()})
+		}
+	}
+}
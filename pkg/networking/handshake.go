@@ -0,0 +1,152 @@
+package networking
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+)
+
+// DefaultChainID identifies the main network
+const DefaultChainID = "wraitheon-main"
+
+// HandshakeTimeout bounds the version/verack exchange
+const HandshakeTimeout = 10 * time.Second
+
+// Services advertised in the version message
+const (
+	// ServiceFullBlocks means the node can serve every block body
+	ServiceFullBlocks uint64 = 1 << iota
+	// ServiceMempool means the node relays unconfirmed transactions
+	ServiceMempool
+)
+
+// ErrIncompatiblePeer is returned when a peer is on another network or speaks
+// an unsupported protocol version
+var ErrIncompatiblePeer = errors.New("incompatible peer")
+
+// ErrSelfConnection is returned when a connection turns out to lead back to
+// this node
+var ErrSelfConnection = errors.New("connected to self")
+
+// Version is the first message each side sends on a new connection
+type Version struct {
+	ProtocolVersion uint16 `json:"protocol_version"`
+	ChainID         string `json:"chain_id"`
+	GenesisHash     string `json:"genesis_hash"`
+	BestHeight      int    `json:"best_height"`
+	Services        uint64 `json:"services"`
+	UserAgent       string `json:"user_agent"`
+	Nonce           uint64 `json:"nonce"`                    // detects connections to ourselves
+	ListenAddress   string `json:"listen_address,omitempty"` // where the peer accepts connections
+}
+
+// Verack acknowledges a peer's version
+type Verack struct{}
+
+// LocalInfo describes this node in the handshake
+type LocalInfo struct {
+	ChainID       string
+	GenesisHash   string
+	Services      uint64
+	UserAgent     string
+	BestHeight    func() int         // current tip height; nil reports 0
+	ListenAddress string             // announced so peers can share and dial us; empty if not listening
+	Key           ed25519.PrivateKey // static key the node ID derives from; nil for a temporary one
+}
+
+// DefaultLocalInfo describes a full node on the main network
+func DefaultLocalInfo() LocalInfo {
+	return LocalInfo{
+		ChainID:     DefaultChainID,
+		GenesisHash: blockchain.GenesisBlock().Hash,
+		Services:    ServiceFullBlocks | ServiceMempool,
+		UserAgent:   "wraitheon/0.1",
+	}
+}
+
+// Handshake exchanges version and verack messages on conn and returns the
+// peer's version. Peers on another chain, with a different genesis block or
+// an unsupported protocol version are refused.
+func (pm *PeerManager) Handshake(conn net.Conn) (Version, error) {
+	if err := conn.SetDeadline(time.Now().Add(HandshakeTimeout)); err != nil {
+		return Version{}, err
+	}
+	defer conn.SetDeadline(time.Time{})
+
+	if err := WriteMessage(conn, CmdVersion, pm.localVersion()); err != nil {
+		return Version{}, err
+	}
+
+	var remote Version
+	gotVersion, gotVerack := false, false
+	for !gotVersion || !gotVerack {
+		message, err := ReadMessage(conn)
+		if err != nil {
+			return Version{}, fmt.Errorf("handshake failed: %v", err)
+		}
+		switch message.Command {
+		case CmdVersion:
+			if gotVersion {
+				return Version{}, errors.New("handshake failed: duplicate version message")
+			}
+			if err := message.Decode(&remote); err != nil {
+				return Version{}, err
+			}
+			if err := pm.checkVersion(remote); err != nil {
+				return Version{}, err
+			}
+			gotVersion = true
+			if err := WriteMessage(conn, CmdVerack, Verack{}); err != nil {
+				return Version{}, err
+			}
+		case CmdVerack:
+			gotVerack = true
+		default:
+			return Version{}, fmt.Errorf("handshake failed: unexpected %s message", message.Command)
+		}
+	}
+	return remote, nil
+}
+
+func (pm *PeerManager) localVersion() Version {
+	height := 0
+	if pm.local.BestHeight != nil {
+		height = pm.local.BestHeight()
+	}
+	return Version{
+		ProtocolVersion: ProtocolVersion,
+		ChainID:         pm.local.ChainID,
+		GenesisHash:     pm.local.GenesisHash,
+		BestHeight:      height,
+		Services:        pm.local.Services,
+		UserAgent:       pm.local.UserAgent,
+		Nonce:           pm.nonce,
+		ListenAddress:   pm.local.ListenAddress,
+	}
+}
+
+func (pm *PeerManager) checkVersion(remote Version) error {
+	switch {
+	case remote.ProtocolVersion < MinProtocolVersion:
+		return fmt.Errorf("%w: protocol version %d", ErrIncompatiblePeer, remote.ProtocolVersion)
+	case remote.ChainID != pm.local.ChainID:
+		return fmt.Errorf("%w: chain %q", ErrIncompatiblePeer, remote.ChainID)
+	case remote.GenesisHash != pm.local.GenesisHash:
+		return fmt.Errorf("%w: genesis %s", ErrIncompatiblePeer, remote.GenesisHash)
+	case remote.Nonce == pm.nonce:
+		return ErrSelfConnection
+	}
+	return nil
+}
+
+func randomNonce() uint64 {
+	var b [8]byte
+	rand.Read(b[:])
+	return binary.BigEndian.Uint64(b[:])
+}
@@ -0,0 +1,136 @@
+package networking
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// MaxKnownInventory bounds the items remembered for each peer
+	MaxKnownInventory = 50000
+	// MaxQueuedInventory bounds transaction announcements waiting for a peer
+	MaxQueuedInventory = 10000
+	// InvTrickleInterval is how often queued transaction announcements are sent
+	InvTrickleInterval = 250 * time.Millisecond
+	// MaxInvPerTrickle bounds the transactions announced to a peer per interval
+	MaxInvPerTrickle = 500
+)
+
+// knownInventory remembers the most recent items a peer has or was told
+// about, forgetting the oldest once full
+type knownInventory struct {
+	items map[InvItem]struct{}
+	order []InvItem
+	next  int // oldest entry once order is full
+}
+
+func newKnownInventory() *knownInventory {
+	return &knownInventory{items: make(map[InvItem]struct{})}
+}
+
+func (k *knownInventory) has(item InvItem) bool {
+	_, exists := k.items[item]
+	return exists
+}
+
+// add records item and reports whether it was new
+func (k *knownInventory) add(item InvItem) bool {
+	if k.has(item) {
+		return false
+	}
+	if len(k.order) < MaxKnownInventory {
+		k.order = append(k.order, item)
+	} else {
+		delete(k.items, k.order[k.next])
+		k.order[k.next] = item
+		k.next = (k.next + 1) % MaxKnownInventory
+	}
+	k.items[item] = struct{}{}
+	return true
+}
+
+// MarkKnown records that the peer with node ID id has items, so they are
+// never announced back to it
+func (pm *PeerManager) MarkKnown(id string, items ...InvItem) {
+	pm.peersMutex.Lock()
+	peer, exists := pm.peers[id]
+	pm.peersMutex.Unlock()
+	if !exists {
+		return
+	}
+	peer.invMu.Lock()
+	defer peer.invMu.Unlock()
+	for _, item := range items {
+		peer.known.add(item)
+	}
+}
+
+// Announce tells every peer that does not know them yet about items. Blocks
+// are announced immediately; transactions are queued and trickled out by
+// RunAnnouncements so bursts are spread over time.
+func (pm *PeerManager) Announce(items ...InvItem) {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	for id, peer := range pm.peers {
+		var blocks []InvItem
+		peer.invMu.Lock()
+		for _, item := range items {
+			if !peer.known.add(item) {
+				continue
+			}
+			if item.Type == InvBlock {
+				blocks = append(blocks, item)
+				continue
+			}
+			if len(peer.queue) >= MaxQueuedInventory {
+				peer.queue = peer.queue[1:]
+			}
+			peer.queue = append(peer.queue, item)
+		}
+		peer.invMu.Unlock()
+
+		if len(blocks) > 0 {
+			pm.sendInv(id, peer, blocks)
+		}
+	}
+}
+
+// RunAnnouncements sends queued transaction announcements until stop is closed
+func (pm *PeerManager) RunAnnouncements(stop <-chan struct{}) {
+	ticker := time.NewTicker(InvTrickleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			pm.flushAnnouncements()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// flushAnnouncements sends up to MaxInvPerTrickle queued items to each peer
+func (pm *PeerManager) flushAnnouncements() {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	for id, peer := range pm.peers {
+		peer.invMu.Lock()
+		n := len(peer.queue)
+		if n > MaxInvPerTrickle {
+			n = MaxInvPerTrickle
+		}
+		items := append([]InvItem(nil), peer.queue[:n]...)
+		peer.queue = peer.queue[n:]
+		peer.invMu.Unlock()
+
+		if len(items) > 0 {
+			pm.sendInv(id, peer, items)
+		}
+	}
+}
+
+func (pm *PeerManager) sendInv(id string, peer *Peer, items []InvItem) {
+	if err := peer.Send(CmdInv, Inv{Items: items}); err != nil {
+		fmt.Printf("Failed to send inv to %s: %v\n", id, err)
+	}
+}
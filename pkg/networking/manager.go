@@ -0,0 +1,357 @@
+package networking
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Reject tells a peer why a transaction it sent was refused
+type Reject struct {
+	TxID   string
+	Code   string
+	Reason string
+}
+
+// PeerInfo describes a connected peer
+type PeerInfo struct {
+	ID       string        `json:"id"`
+	Address  string        `json:"address"`
+	Outbound bool          `json:"outbound"`
+	Version  Version       `json:"version"`
+	Score    int           `json:"score"`
+	PingTime time.Duration `json:"ping_time"` // round trip of the last answered ping
+}
+
+// info describes the peer. The caller must hold the manager's peersMutex.
+func (p *Peer) info() PeerInfo {
+	return PeerInfo{
+		ID:       p.id,
+		Address:  p.address,
+		Outbound: p.outbound,
+		Version:  p.version,
+		Score:    p.score,
+		PingTime: p.PingTime(),
+	}
+}
+
+var (
+	// ErrDuplicatePeer is returned when a node is already connected
+	ErrDuplicatePeer = errors.New("peer already connected")
+	// ErrShutdown is returned when connecting after Shutdown
+	ErrShutdown = errors.New("peer manager is shut down")
+)
+
+// MessageHandler is called for every message received from a connected peer,
+// identified by its node ID
+type MessageHandler func(peer string, message Message)
+
+// PeerManager tracks the connected peers by node ID
+type PeerManager struct {
+	peers       map[string]*Peer
+	peersMutex  sync.Mutex
+	listener    net.Listener // set by StartServer
+	closed      bool         // set by Shutdown
+	local       LocalInfo
+	identity    *Identity
+	identityErr error  // set if the identity certificate could not be created
+	nonce       uint64 // sent in our version message to detect self connections
+	handler     MessageHandler
+	limits      PeerLimits
+	bans        *BanList
+}
+
+func NewPeerManager() *PeerManager {
+	return NewPeerManagerWithInfo(DefaultLocalInfo())
+}
+
+// NewPeerManagerWithInfo creates a peer manager announcing local in handshakes
+// with the default limits and an in-memory ban list
+func NewPeerManagerWithInfo(local LocalInfo) *PeerManager {
+	return NewPeerManagerWithConfig(local, DefaultPeerLimits(), NewBanList(""))
+}
+
+// NewPeerManagerWithConfig creates a peer manager announcing local in
+// handshakes, enforcing limits and the bans in bans. Without local.Key the
+// node authenticates with a temporary key.
+func NewPeerManagerWithConfig(local LocalInfo, limits PeerLimits, bans *BanList) *PeerManager {
+	key := local.Key
+	if key == nil {
+		_, key, _ = ed25519.GenerateKey(nil)
+	}
+	identity, err := NewIdentity(key)
+	return &PeerManager{
+		peers:       make(map[string]*Peer),
+		local:       local,
+		identity:    identity,
+		identityErr: err,
+		nonce:       randomNonce(),
+		limits:      limits,
+		bans:        bans,
+	}
+}
+
+// ID returns this node's ID
+func (pm *PeerManager) ID() string {
+	if pm.identity == nil {
+		return ""
+	}
+	return pm.identity.ID
+}
+
+// AcceptInbound reports whether a new connection from address may proceed to
+// the handshake
+func (pm *PeerManager) AcceptInbound(address string) error {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	return pm.checkInboundLocked(address)
+}
+
+// AddPeer registers an inbound connection from address that has completed
+// the handshake, unless a limit was reached in the meantime or the node is
+// already connected
+func (pm *PeerManager) AddPeer(id, address string, conn net.Conn, version Version) error {
+	return pm.addPeer(id, address, conn, version, false)
+}
+
+func (pm *PeerManager) addPeer(id, address string, conn net.Conn, version Version, outbound bool) error {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	if err := pm.checkIdentityLocked(id); err != nil {
+		return err
+	}
+	check := pm.checkInboundLocked
+	if outbound {
+		check = pm.checkOutboundLocked
+	}
+	if err := check(address); err != nil {
+		return err
+	}
+	peer := newPeer(id, address, conn, version, outbound)
+	pm.peers[id] = peer
+	peer.start(pm.deliver, pm.peerClosed)
+	return nil
+}
+
+// checkIdentityLocked refuses our own ID and nodes that are already
+// connected, and everything once the manager is shut down
+func (pm *PeerManager) checkIdentityLocked(id string) error {
+	if pm.closed {
+		return ErrShutdown
+	}
+	if id == pm.ID() {
+		return ErrSelfConnection
+	}
+	if _, exists := pm.peers[id]; exists {
+		return fmt.Errorf("%w: %s", ErrDuplicatePeer, id)
+	}
+	return nil
+}
+
+// ConnectPeer dials address, secures the connection, performs the handshake
+// and registers the peer. An address of the form id@host:port only accepts
+// the node with that ID.
+func (pm *PeerManager) ConnectPeer(address string) (PeerInfo, error) {
+	if pm.identityErr != nil {
+		return PeerInfo{}, pm.identityErr
+	}
+	pinnedID, hostPort := SplitPeerAddress(address)
+	pm.peersMutex.Lock()
+	err := pm.checkOutboundLocked(hostPort)
+	if err == nil && pm.closed {
+		err = ErrShutdown
+	}
+	if err == nil && pinnedID != "" {
+		err = pm.checkIdentityLocked(pinnedID)
+	}
+	pm.peersMutex.Unlock()
+	if err != nil {
+		return PeerInfo{}, err
+	}
+
+	raw, err := ConnectToPeer(hostPort)
+	if err != nil {
+		return PeerInfo{}, err
+	}
+	if pm.bans.IsBanned(hostOf(raw.RemoteAddr().String())) {
+		raw.Close()
+		return PeerInfo{}, ErrBanned
+	}
+	conn, id, err := pm.identity.secureClient(raw, pinnedID)
+	if err != nil {
+		raw.Close()
+		return PeerInfo{}, fmt.Errorf("peer %s: %w", address, err)
+	}
+	version, err := pm.Handshake(conn)
+	if err == nil {
+		err = pm.addPeer(id, hostPort, conn, version, true)
+	}
+	if err != nil {
+		conn.Close()
+		return PeerInfo{}, fmt.Errorf("peer %s: %w", address, err)
+	}
+	info, _ := pm.PeerInfo(id)
+	return info, nil
+}
+
+// SetHandler sets the function called for messages from every peer
+func (pm *PeerManager) SetHandler(handler MessageHandler) {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	pm.handler = handler
+}
+
+// deliver passes a message from peer to the handler
+func (pm *PeerManager) deliver(peer *Peer, message Message) {
+	pm.peersMutex.Lock()
+	handler := pm.handler
+	pm.peersMutex.Unlock()
+	if handler != nil {
+		handler(peer.id, message)
+	}
+}
+
+// peerClosed drops a peer whose connection ended, scoring it if it sent a
+// malformed frame
+func (pm *PeerManager) peerClosed(peer *Peer, err error) {
+	if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, ErrPeerClosed) {
+		fmt.Printf("Disconnected from %s: %v\n", peer.id, err)
+	}
+	if score := frameScore(err); score > 0 {
+		pm.Misbehaving(peer.id, score, err.Error())
+	}
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	if pm.peers[peer.id] == peer {
+		delete(pm.peers, peer.id)
+	}
+}
+
+// Peer returns the connected peer with the given ID
+func (pm *PeerManager) Peer(id string) (*Peer, bool) {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	peer, exists := pm.peers[id]
+	return peer, exists
+}
+
+// PeerVersion returns the version announced by the peer with the given ID
+func (pm *PeerManager) PeerVersion(id string) (Version, bool) {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	peer, exists := pm.peers[id]
+	if !exists {
+		return Version{}, false
+	}
+	return peer.version, true
+}
+
+// PeerInfo describes the peer with the given ID
+func (pm *PeerManager) PeerInfo(id string) (PeerInfo, bool) {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	peer, exists := pm.peers[id]
+	if !exists {
+		return PeerInfo{}, false
+	}
+	return peer.info(), true
+}
+
+// PeerInfos describes every connected peer
+func (pm *PeerManager) PeerInfos() []PeerInfo {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	infos := make([]PeerInfo, 0, len(pm.peers))
+	for _, peer := range pm.peers {
+		infos = append(infos, peer.info())
+	}
+	return infos
+}
+
+// Peers returns the IDs of connected peers
+func (pm *PeerManager) Peers() []string {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	ids := make([]string, 0, len(pm.peers))
+	for id := range pm.peers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// OutboundPeers returns the IDs of peers we dialed
+func (pm *PeerManager) OutboundPeers() []string {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	var ids []string
+	for id, peer := range pm.peers {
+		if peer.outbound {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// RemovePeer disconnects the peer with the given ID
+func (pm *PeerManager) RemovePeer(id string) {
+	pm.peersMutex.Lock()
+	peer, exists := pm.peers[id]
+	delete(pm.peers, id)
+	pm.peersMutex.Unlock()
+	if exists {
+		peer.Close()
+	}
+}
+
+// Send frames payload under command and queues it for the peer with the given ID
+func (pm *PeerManager) Send(id string, command string, payload interface{}) error {
+	peer, exists := pm.Peer(id)
+	if !exists {
+		return fmt.Errorf("peer %s is not connected", id)
+	}
+	return peer.Send(command, payload)
+}
+
+// Broadcast frames payload under command once and queues it for every peer
+func (pm *PeerManager) Broadcast(command string, payload interface{}) {
+	frame, err := EncodeMessage(command, payload)
+	if err != nil {
+		fmt.Printf("Failed to encode %s message: %v\n", command, err)
+		return
+	}
+
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	for id, peer := range pm.peers {
+		if err := peer.enqueue(frame); err != nil {
+			fmt.Printf("Failed to send message to %s: %v\n", id, err)
+		}
+	}
+}
+
+// Shutdown stops accepting connections, disconnects every peer and waits for
+// their goroutines to finish. Connections cannot be made afterwards.
+func (pm *PeerManager) Shutdown() {
+	pm.peersMutex.Lock()
+	pm.closed = true
+	listener := pm.listener
+	peers := make([]*Peer, 0, len(pm.peers))
+	for _, peer := range pm.peers {
+		peers = append(peers, peer)
+	}
+	pm.peersMutex.Unlock()
+
+	if listener != nil {
+		listener.Close()
+	}
+	for _, peer := range peers {
+		peer.Close()
+	}
+	for _, peer := range peers {
+		peer.Wait()
+	}
+}
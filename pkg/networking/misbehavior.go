@@ -0,0 +1,152 @@
+package networking
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Misbehavior scores. A peer whose total reaches the ban threshold is
+// disconnected and its host banned.
+const (
+	ScoreInvalidBlock = 100 // block or header chain that fails validation
+	ScoreOversize     = 100 // frame or list beyond the protocol limits
+	ScoreBadWork      = 50  // hash that does not match or misses the target
+	ScoreMalformed    = 20  // corrupt frame or undecodable payload
+	ScoreInvalidTx    = 10  // transaction with a bad signature or missing fields
+	ScoreSpam         = 10  // message dropped by a rate limit
+)
+
+var (
+	// ErrBanned is returned when connecting to or from a banned host
+	ErrBanned = errors.New("host is banned")
+	// ErrTooManyPeers is returned when a connection limit is reached
+	ErrTooManyPeers = errors.New("too many peers")
+)
+
+// PeerLimits bounds connections and sets how misbehavior is punished
+type PeerLimits struct {
+	MaxInbound   int
+	MaxOutbound  int
+	MaxPerIP     int // inbound connections from one IP; loopback is exempt
+	BanThreshold int
+	BanDuration  time.Duration
+}
+
+// DefaultPeerLimits returns the limits used by NewPeerManager
+func DefaultPeerLimits() PeerLimits {
+	return PeerLimits{
+		MaxInbound:   64,
+		MaxOutbound:  16,
+		MaxPerIP:     4,
+		BanThreshold: 100,
+		BanDuration:  24 * time.Hour,
+	}
+}
+
+// Misbehaving adds score to the peer with the given ID. Once its total
+// reaches the ban threshold the peer's host is banned and the peer
+// disconnected.
+func (pm *PeerManager) Misbehaving(id string, score int, reason string) {
+	pm.peersMutex.Lock()
+	peer, exists := pm.peers[id]
+	if !exists {
+		pm.peersMutex.Unlock()
+		return
+	}
+	peer.score += score
+	total := peer.score
+	host := hostOf(peer.address)
+	pm.peersMutex.Unlock()
+
+	fmt.Printf("Peer %s misbehaved (%s), score %d\n", id, reason, total)
+	if total < pm.limits.BanThreshold {
+		return
+	}
+	if err := pm.bans.Ban(host, pm.limits.BanDuration, reason); err != nil {
+		fmt.Printf("Failed to save ban of %s: %v\n", host, err)
+	}
+	fmt.Printf("Banned %s for %s: %s\n", host, pm.limits.BanDuration, reason)
+	pm.RemovePeer(id)
+}
+
+// Score returns the misbehavior score of the peer with the given ID
+func (pm *PeerManager) Score(id string) int {
+	pm.peersMutex.Lock()
+	defer pm.peersMutex.Unlock()
+	if peer, exists := pm.peers[id]; exists {
+		return peer.score
+	}
+	return 0
+}
+
+// BanList returns the bans enforced by the peer manager
+func (pm *PeerManager) BanList() *BanList {
+	return pm.bans
+}
+
+// frameScore returns the misbehavior score for a failure to read a frame, or
+// 0 if the failure is not the peer's fault
+func frameScore(err error) int {
+	switch {
+	case errors.Is(err, ErrPayloadTooLarge):
+		return ScoreOversize
+	case errors.Is(err, ErrBadMagic), errors.Is(err, ErrBadChecksum), errors.Is(err, ErrUnknownCommand):
+		return ScoreMalformed
+	}
+	return 0
+}
+
+// checkInboundLocked refuses a connection from address when its host is
+// banned or an inbound limit is reached. The caller must hold peersMutex.
+func (pm *PeerManager) checkInboundLocked(address string) error {
+	host := hostOf(address)
+	if pm.bans.IsBanned(host) {
+		return ErrBanned
+	}
+	inbound, sameIP := 0, 0
+	for _, peer := range pm.peers {
+		if peer.outbound {
+			continue
+		}
+		inbound++
+		if hostOf(peer.address) == host {
+			sameIP++
+		}
+	}
+	if inbound >= pm.limits.MaxInbound {
+		return fmt.Errorf("%w: %d inbound", ErrTooManyPeers, inbound)
+	}
+	if ip := net.ParseIP(host); (ip == nil || !ip.IsLoopback()) && sameIP >= pm.limits.MaxPerIP {
+		return fmt.Errorf("%w: %d from %s", ErrTooManyPeers, sameIP, host)
+	}
+	return nil
+}
+
+// checkOutboundLocked refuses to dial address when its host is banned or the
+// outbound limit is reached. The caller must hold peersMutex.
+func (pm *PeerManager) checkOutboundLocked(address string) error {
+	if pm.bans.IsBanned(hostOf(address)) {
+		return ErrBanned
+	}
+	outbound := 0
+	for _, peer := range pm.peers {
+		if peer.outbound {
+			outbound++
+		}
+	}
+	if outbound >= pm.limits.MaxOutbound {
+		return fmt.Errorf("%w: %d outbound", ErrTooManyPeers, outbound)
+	}
+	return nil
+}
+
+// PayloadScore returns the misbehavior score for a payload that failed to
+// decode
+func PayloadScore(err error) int {
+	if errors.Is(err, ErrPayloadTooLarge) {
+		return ScoreOversize
+	}
+	return ScoreMalformed
+}
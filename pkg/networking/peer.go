@@ -1,57 +1,252 @@
 package networking
 
 import (
-	"encoding/gob"
-	"fmt"
+	"errors"
 	"net"
 	"sync"
+	"time"
 )
 
+const (
+	// SendQueueSize bounds the frames waiting to be written to a peer; a peer
+	// that falls this far behind is disconnected
+	SendQueueSize = 256
+	// WriteTimeout bounds how long writing one frame may take
+	WriteTimeout = 30 * time.Second
+	// PingInterval is how often peers are pinged to check they are alive
+	PingInterval = 30 * time.Second
+	// PongTimeout is how long a peer has to answer a ping
+	PongTimeout = 20 * time.Second
+)
+
+var (
+	// ErrPeerClosed is returned when sending to a peer that has disconnected
+	ErrPeerClosed = errors.New("peer closed")
+	// ErrSendQueueFull is returned, and the peer disconnected, when a peer
+	// does not read fast enough to keep its send queue from overflowing
+	ErrSendQueueFull = errors.New("send queue full")
+	// ErrPingTimeout is the reason a peer that stopped answering pings is
+	// disconnected
+	ErrPingTimeout = errors.New("ping timeout")
+)
+
+// Peer is an established connection to another node, inbound or outbound.
+// A reader goroutine delivers incoming messages, a writer goroutine drains
+// the send queue so callers never block on the network, and a pinger checks
+// that the peer is still alive. Ping and pong are answered internally.
 type Peer struct {
-	Address string
+	id       string   // node ID authenticated by the transport
+	address  string   // network address we dialed or the peer connected from
+	conn     net.Conn // encrypted transport
+	version  Version  // announced by the peer during the handshake
+	outbound bool     // we dialed the peer
+	score    int      // misbehavior score, guarded by the manager's peersMutex
+
+	sendQueue chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	closeErr  error // why the peer was closed, set before done is closed
+	wg        sync.WaitGroup
+
+	pingMu    sync.Mutex
+	pingNonce uint64        // nonce of the outstanding ping, 0 if none
+	pingSent  time.Time     // when the outstanding ping was sent
+	pingTime  time.Duration // round trip of the last answered ping
+
+	invMu sync.Mutex
+	known *knownInventory // items the peer has or was told about
+	queue []InvItem       // transaction announcements not yet sent
+}
+
+func newPeer(id, address string, conn net.Conn, version Version, outbound bool) *Peer {
+	return &Peer{
+		id:        id,
+		address:   address,
+		conn:      conn,
+		version:   version,
+		outbound:  outbound,
+		sendQueue: make(chan []byte, SendQueueSize),
+		done:      make(chan struct{}),
+		known:     newKnownInventory(),
+	}
 }
 
-type Message struct {
-	Type    string // e.g., "transaction"
-	Payload string // the actual data
+// ID returns the peer's node ID
+func (p *Peer) ID() string { return p.id }
+
+// Address returns the network address of the peer's connection
+func (p *Peer) Address() string { return p.address }
+
+// Outbound reports whether we dialed the peer
+func (p *Peer) Outbound() bool { return p.outbound }
+
+// Version returns the version the peer announced in the handshake
+func (p *Peer) Version() Version { return p.version }
+
+// PingTime returns the round trip of the last answered ping
+func (p *Peer) PingTime() time.Duration {
+	p.pingMu.Lock()
+	defer p.pingMu.Unlock()
+	return p.pingTime
+}
+
+// start runs the peer's goroutines. onMessage is called from the reader for
+// every message other than ping and pong; onClose is called once after the
+// reader stops, with the reason the peer was closed.
+func (p *Peer) start(onMessage func(*Peer, Message), onClose func(*Peer, error)) {
+	p.wg.Add(3)
+	go p.readLoop(onMessage, onClose)
+	go p.writeLoop()
+	go p.pingLoop()
 }
 
-type PeerManager struct {
-	peers      map[string]net.Conn
-	peersMutex sync.Mutex
+// Send frames payload under command and queues it for the writer
+func (p *Peer) Send(command string, payload interface{}) error {
+	frame, err := EncodeMessage(command, payload)
+	if err != nil {
+		return err
+	}
+	return p.enqueue(frame)
 }
 
-func NewPeerManager() *PeerManager {
-	return &PeerManager{
-		peers: make(map[string]net.Conn),
+// enqueue queues an encoded frame without blocking. A peer whose queue is
+// full is disconnected.
+func (p *Peer) enqueue(frame []byte) error {
+	select {
+	case <-p.done:
+		return ErrPeerClosed
+	default:
+	}
+	select {
+	case p.sendQueue <- frame:
+		return nil
+	case <-p.done:
+		return ErrPeerClosed
+	default:
+		p.closeWithError(ErrSendQueueFull)
+		return ErrSendQueueFull
 	}
 }
 
-func (pm *PeerManager) AddPeer(address string, conn net.Conn) {
-	pm.peersMutex.Lock()
-	defer pm.peersMutex.Unlock()
-	pm.peers[address] = conn
+// Close disconnects the peer and stops its goroutines
+func (p *Peer) Close() {
+	p.closeWithError(ErrPeerClosed)
+}
+
+func (p *Peer) closeWithError(err error) {
+	p.closeOnce.Do(func() {
+		p.closeErr = err
+		close(p.done)
+		p.conn.Close()
+	})
 }
 
-func (pm *PeerManager) RemovePeer(address string) {
-	pm.peersMutex.Lock()
-	defer pm.peersMutex.Unlock()
-	if conn, exists := pm.peers[address]; exists {
-		conn.Close()
-		delete(pm.peers, address)
+// Done is closed when the peer disconnects
+func (p *Peer) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait blocks until the peer's goroutines have stopped
+func (p *Peer) Wait() {
+	p.wg.Wait()
+}
+
+func (p *Peer) readLoop(onMessage func(*Peer, Message), onClose func(*Peer, error)) {
+	defer p.wg.Done()
+	var err error
+	for {
+		var message Message
+		message, err = ReadMessage(p.conn)
+		if err != nil {
+			break
+		}
+		switch message.Command {
+		case CmdPing:
+			var ping Ping
+			if err = message.Decode(&ping); err != nil {
+				break
+			}
+			p.Send(CmdPong, Pong{Nonce: ping.Nonce})
+			continue
+		case CmdPong:
+			var pong Pong
+			if err = message.Decode(&pong); err != nil {
+				break
+			}
+			p.handlePong(pong)
+			continue
+		default:
+			onMessage(p, message)
+			continue
+		}
+		break // a ping or pong failed to decode
+	}
+
+	select {
+	case <-p.done:
+		err = p.closeErr // closed locally; the read error is a consequence
+	default:
+		p.closeWithError(err)
 	}
+	onClose(p, err)
 }
 
-func (pm *PeerManager) Broadcast(message Message) {
-	pm.peersMutex.Lock()
-	defer pm.peersMutex.Unlock()
-	for address, conn := range pm.peers {
-		go func(addr string, c net.Conn) {
-			encoder := gob.NewEncoder(c)
-			err := encoder.Encode(message)
-			if err != nil {
-				fmt.Printf("Failed to send message to %s: %v\n", addr, err)
+func (p *Peer) writeLoop() {
+	defer p.wg.Done()
+	for {
+		select {
+		case frame := <-p.sendQueue:
+			p.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+			if _, err := p.conn.Write(frame); err != nil {
+				p.closeWithError(err)
+				return
 			}
-		}(address, conn)
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Peer) pingLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !p.ping() {
+				p.closeWithError(ErrPingTimeout)
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// ping sends a new ping unless one is outstanding, and reports false if the
+// outstanding ping has gone unanswered for too long
+func (p *Peer) ping() bool {
+	p.pingMu.Lock()
+	if p.pingNonce != 0 {
+		expired := time.Since(p.pingSent) > PongTimeout
+		p.pingMu.Unlock()
+		return !expired
+	}
+	nonce := randomNonce() | 1 // never 0, which means no ping is outstanding
+	p.pingNonce = nonce
+	p.pingSent = time.Now()
+	p.pingMu.Unlock()
+
+	p.Send(CmdPing, Ping{Nonce: nonce})
+	return true
+}
+
+func (p *Peer) handlePong(pong Pong) {
+	p.pingMu.Lock()
+	defer p.pingMu.Unlock()
+	if pong.Nonce != 0 && pong.Nonce == p.pingNonce {
+		p.pingTime = time.Since(p.pingSent)
+		p.pingNonce = 0
 	}
 }
@@ -1,40 +1,71 @@
 package networking
 
 import (
-	"encoding/gob"
+	"errors"
 	"fmt"
 	"net"
 )
 
-func StartServer(address string, pm *PeerManager, onMessage func(message Message)) error {
+// StartServer accepts peer connections on address and calls onMessage for
+// every frame received from any peer, inbound or outbound, along with the
+// node ID of the sending peer. Connections are encrypted with TLS 1.3 and
+// authenticated by node key, then peers must complete the version handshake;
+// a peer that fails either or sends a malformed frame is disconnected.
+// Banned hosts and connections beyond the inbound limits are refused. The
+// listener is closed by Shutdown.
+func StartServer(address string, pm *PeerManager, onMessage MessageHandler) error {
+	if pm.identityErr != nil {
+		return fmt.Errorf("failed to start server: %v", pm.identityErr)
+	}
 	listener, err := net.Listen("tcp", address)
 	if err != nil {
 		return fmt.Errorf("failed to start server: %v", err)
 	}
+	pm.peersMutex.Lock()
+	if pm.closed {
+		pm.peersMutex.Unlock()
+		listener.Close()
+		return ErrShutdown
+	}
+	pm.listener = listener
+	pm.handler = onMessage
+	pm.peersMutex.Unlock()
 	fmt.Printf("Server started at %s\n", address)
 
 	go func() {
 		for {
 			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return // Shutdown closed the listener
+			}
 			if err != nil {
 				fmt.Printf("Failed to accept connection: %v\n", err)
 				continue
 			}
 			fmt.Printf("New connection from %s\n", conn.RemoteAddr().String())
-			pm.AddPeer(conn.RemoteAddr().String(), conn)
 
-			// Handle incoming messages
+			// Check limits and bans, secure the connection and handshake,
+			// then hand the connection to a Peer
 			go func(c net.Conn) {
-				defer pm.RemovePeer(c.RemoteAddr().String())
-				decoder := gob.NewDecoder(c)
-				for {
-					var message Message
-					err := decoder.Decode(&message)
-					if err != nil {
-						fmt.Printf("Failed to decode message: %v\n", err)
-						break
-					}
-					onMessage(message)
+				address := c.RemoteAddr().String()
+				if err := pm.AcceptInbound(address); err != nil {
+					fmt.Printf("Refusing %s: %v\n", address, err)
+					c.Close()
+					return
+				}
+				secured, id, err := pm.identity.secureServer(c)
+				if err != nil {
+					fmt.Printf("Disconnecting %s: %v\n", address, err)
+					c.Close()
+					return
+				}
+				version, err := pm.Handshake(secured)
+				if err == nil {
+					err = pm.AddPeer(id, address, secured, version)
+				}
+				if err != nil {
+					fmt.Printf("Disconnecting %s (%s): %v\n", address, id, err)
+					secured.Close()
 				}
 			}(conn)
 		}
@@ -0,0 +1,168 @@
+package networking
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+// NodeIDSize is the length in bytes of a node ID before hex encoding
+const NodeIDSize = 20
+
+// ErrIdentityMismatch is returned when a peer's key does not match the node
+// ID pinned in the address that was dialed
+var ErrIdentityMismatch = errors.New("peer identity does not match")
+
+// NodeIDFromKey derives a node ID from a node's static public key
+func NodeIDFromKey(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:NodeIDSize])
+}
+
+// SplitPeerAddress splits an address of the form id@host:port into the
+// pinned node ID and the network address. Addresses without an ID return an
+// empty ID.
+func SplitPeerAddress(address string) (id string, hostPort string) {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return address[:at], address[at+1:]
+	}
+	return "", address
+}
+
+// JoinPeerAddress pins id to hostPort, giving an address of the form id@host:port
+func JoinPeerAddress(id, hostPort string) string {
+	if id == "" {
+		return hostPort
+	}
+	return id + "@" + hostPort
+}
+
+// Identity is the static key a node authenticates with, wrapped in the
+// self-signed certificate presented during the TLS handshake
+type Identity struct {
+	ID          string
+	Key         ed25519.PrivateKey
+	certificate tls.Certificate
+}
+
+// NewIdentity creates the identity for key
+func NewIdentity(key ed25519.PrivateKey) (*Identity, error) {
+	pub := key.Public().(ed25519.PublicKey)
+	id := NodeIDFromKey(pub)
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate serial: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: id},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, pub, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %v", err)
+	}
+	return &Identity{
+		ID:          id,
+		Key:         key,
+		certificate: tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+	}, nil
+}
+
+// tlsConfig returns the TLS 1.3 configuration for one connection. Both sides
+// present their self-signed identity certificate; instead of a CA chain the
+// peer's certificate is checked to be self-signed by an ed25519 key, and that
+// key must match pinnedID when one is given.
+func (identity *Identity) tlsConfig(pinnedID string) *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS13,
+		Certificates:       []tls.Certificate{identity.certificate},
+		ClientAuth:         tls.RequireAnyClientCert,
+		InsecureSkipVerify: true, // replaced by VerifyPeerCertificate
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			id, err := verifyPeerCertificate(rawCerts)
+			if err != nil {
+				return err
+			}
+			if pinnedID != "" && id != pinnedID {
+				return fmt.Errorf("%w: expected %s, got %s", ErrIdentityMismatch, pinnedID, id)
+			}
+			return nil
+		},
+	}
+}
+
+// verifyPeerCertificate checks that the peer presented a single self-signed
+// ed25519 certificate and returns the node ID of its key
+func verifyPeerCertificate(rawCerts [][]byte) (string, error) {
+	if len(rawCerts) != 1 {
+		return "", fmt.Errorf("expected one certificate, got %d", len(rawCerts))
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid certificate: %v", err)
+	}
+	pub, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return "", errors.New("certificate key is not ed25519")
+	}
+	if err := cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature); err != nil {
+		return "", fmt.Errorf("certificate is not self-signed: %v", err)
+	}
+	return NodeIDFromKey(pub), nil
+}
+
+// peerID returns the node ID of the peer on an established TLS connection
+func peerID(conn *tls.Conn) (string, error) {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", errors.New("peer presented no certificate")
+	}
+	pub, ok := certs[0].PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return "", errors.New("certificate key is not ed25519")
+	}
+	return NodeIDFromKey(pub), nil
+}
+
+// secureClient runs the TLS handshake as the dialing side and returns the
+// encrypted connection with the authenticated peer ID
+func (identity *Identity) secureClient(conn net.Conn, pinnedID string) (*tls.Conn, string, error) {
+	return secure(tls.Client(conn, identity.tlsConfig(pinnedID)))
+}
+
+// secureServer runs the TLS handshake as the accepting side
+func (identity *Identity) secureServer(conn net.Conn) (*tls.Conn, string, error) {
+	return secure(tls.Server(conn, identity.tlsConfig("")))
+}
+
+func secure(conn *tls.Conn) (*tls.Conn, string, error) {
+	if err := conn.SetDeadline(time.Now().Add(HandshakeTimeout)); err != nil {
+		return nil, "", err
+	}
+	if err := conn.Handshake(); err != nil {
+		return nil, "", fmt.Errorf("tls handshake failed: %w", err)
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return nil, "", err
+	}
+	id, err := peerID(conn)
+	if err != nil {
+		return nil, "", err
+	}
+	return conn, id, nil
+}
@@ -0,0 +1,258 @@
+package networking
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+)
+
+// Every frame starts with a fixed header:
+//
+//	magic    4 bytes  network identifier
+//	version  2 bytes  protocol version of the sender
+//	command 12 bytes  ASCII command name, zero padded
+//	length   4 bytes  payload length
+//	checksum 4 bytes  first bytes of SHA-256(payload)
+//
+// followed by the JSON-encoded payload for the command.
+const (
+	// NetworkMagic identifies frames belonging to this network
+	NetworkMagic uint32 = 0x5742434e // "WBCN"
+	// ProtocolVersion is the version of the wire protocol spoken by this node
+	ProtocolVersion uint16 = 1
+	// MinProtocolVersion is the oldest version this node accepts
+	MinProtocolVersion uint16 = 1
+
+	commandSize     = 12
+	checksumSize    = 4
+	frameHeaderSize = 4 + 2 + commandSize + 4 + checksumSize
+
+	// MaxPayloadSize bounds any payload regardless of command
+	MaxPayloadSize = 4 << 20
+	// MaxInvItems bounds the entries of an inv or getdata message
+	MaxInvItems = 50000
+	// MaxHeaders bounds the headers in a single headers message
+	MaxHeaders = 2000
+	// MaxLocatorHashes bounds the block locator of a getheaders message
+	MaxLocatorHashes = 101
+	// MaxAddrItems bounds the addresses in an addr message
+	MaxAddrItems = 1000
+)
+
+// Commands carried in the frame header
+const (
+	CmdTx         = "tx"
+	CmdBlock      = "block"
+	CmdHeaders    = "headers"
+	CmdInv        = "inv"
+	CmdGetData    = "getdata"
+	CmdPing       = "ping"
+	CmdPong       = "pong"
+	CmdReject     = "reject"
+	CmdVersion    = "version"
+	CmdVerack     = "verack"
+	CmdGetHeaders = "getheaders"
+	CmdGetAddr    = "getaddr"
+	CmdAddr       = "addr"
+)
+
+// maxPayloads is the payload limit for each known command
+var maxPayloads = map[string]uint32{
+	CmdTx:      64 << 10,
+	CmdBlock:   MaxPayloadSize,
+	CmdHeaders: MaxPayloadSize,
+	CmdInv:     MaxPayloadSize,
+	CmdGetData: MaxPayloadSize,
+	CmdPing:    64,
+	CmdPong:    64,
+	CmdReject:  4 << 10,
+	CmdVersion: 1 << 10,
+	CmdVerack:  16,
+
+	CmdGetHeaders: 16 << 10,
+	CmdGetAddr:    16,
+	CmdAddr:       128 << 10,
+}
+
+var (
+	// ErrBadMagic is returned for frames from another network or a corrupt stream
+	ErrBadMagic = errors.New("bad network magic")
+	// ErrBadChecksum is returned when a payload does not match its checksum
+	ErrBadChecksum = errors.New("payload checksum mismatch")
+	// ErrUnknownCommand is returned for commands this node does not understand
+	ErrUnknownCommand = errors.New("unknown command")
+	// ErrPayloadTooLarge is returned when a frame exceeds its command's size limit
+	ErrPayloadTooLarge = errors.New("payload too large")
+)
+
+// InvType identifies the kind of object an inventory entry refers to
+type InvType uint8
+
+const (
+	InvTx InvType = iota + 1
+	InvBlock
+)
+
+// InvItem announces or requests a transaction or block by hash
+type InvItem struct {
+	Type InvType `json:"type"`
+	Hash string  `json:"hash"`
+}
+
+// Inv announces objects the sender has
+type Inv struct {
+	Items []InvItem `json:"items"`
+}
+
+// GetData requests the full objects for previously announced items
+type GetData struct {
+	Items []InvItem `json:"items"`
+}
+
+// GetHeaders asks for the headers following the first locator hash the peer
+// knows, up to MaxHeaders or StopHash
+type GetHeaders struct {
+	Locator  []string `json:"locator"`
+	StopHash string   `json:"stop_hash,omitempty"`
+}
+
+// Headers carries block headers without their transactions
+type Headers struct {
+	Headers []blockchain.Block `json:"headers"`
+}
+
+// GetAddr asks a peer for addresses of other nodes it knows
+type GetAddr struct{}
+
+// Addr shares the listen addresses of known nodes
+type Addr struct {
+	Addresses []string `json:"addresses"`
+}
+
+// Ping asks the peer to answer with a Pong carrying the same nonce
+type Ping struct {
+	Nonce uint64 `json:"nonce"`
+}
+
+// Pong answers a Ping
+type Pong struct {
+	Nonce uint64 `json:"nonce"`
+}
+
+// Message is a decoded frame whose payload has not been interpreted yet
+type Message struct {
+	Command string
+	Version uint16
+	Payload []byte
+}
+
+// Decode unmarshals the payload into out and checks the limits of list payloads
+func (m Message) Decode(out interface{}) error {
+	if err := json.Unmarshal(m.Payload, out); err != nil {
+		return fmt.Errorf("invalid %s payload: %v", m.Command, err)
+	}
+	switch v := out.(type) {
+	case *Inv:
+		if len(v.Items) > MaxInvItems {
+			return fmt.Errorf("%w: %d inventory items", ErrPayloadTooLarge, len(v.Items))
+		}
+	case *GetData:
+		if len(v.Items) > MaxInvItems {
+			return fmt.Errorf("%w: %d inventory items", ErrPayloadTooLarge, len(v.Items))
+		}
+	case *Headers:
+		if len(v.Headers) > MaxHeaders {
+			return fmt.Errorf("%w: %d headers", ErrPayloadTooLarge, len(v.Headers))
+		}
+	case *GetHeaders:
+		if len(v.Locator) > MaxLocatorHashes {
+			return fmt.Errorf("%w: %d locator hashes", ErrPayloadTooLarge, len(v.Locator))
+		}
+	case *Addr:
+		if len(v.Addresses) > MaxAddrItems {
+			return fmt.Errorf("%w: %d addresses", ErrPayloadTooLarge, len(v.Addresses))
+		}
+	}
+	return nil
+}
+
+// EncodeMessage frames payload, encoded as JSON, under command
+func EncodeMessage(command string, payload interface{}) ([]byte, error) {
+	limit, known := maxPayloads[command]
+	if !known {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownCommand, command)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s payload: %v", command, err)
+	}
+	if uint32(len(data)) > limit {
+		return nil, fmt.Errorf("%w: %s payload of %d bytes", ErrPayloadTooLarge, command, len(data))
+	}
+
+	frame := make([]byte, frameHeaderSize+len(data))
+	binary.BigEndian.PutUint32(frame[0:4], NetworkMagic)
+	binary.BigEndian.PutUint16(frame[4:6], ProtocolVersion)
+	copy(frame[6:6+commandSize], command)
+	binary.BigEndian.PutUint32(frame[18:22], uint32(len(data)))
+	copy(frame[22:26], checksum(data))
+	copy(frame[frameHeaderSize:], data)
+	return frame, nil
+}
+
+// WriteMessage frames payload under command and writes it to w
+func WriteMessage(w io.Writer, command string, payload interface{}) error {
+	frame, err := EncodeMessage(command, payload)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(frame); err != nil {
+		return fmt.Errorf("failed to send %s: %v", command, err)
+	}
+	return nil
+}
+
+// ReadMessage reads one frame from r. The header is checked before the
+// payload is read, so oversized frames are refused without buffering them.
+func ReadMessage(r io.Reader) (Message, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Message{}, err
+	}
+	if binary.BigEndian.Uint32(header[0:4]) != NetworkMagic {
+		return Message{}, ErrBadMagic
+	}
+	version := binary.BigEndian.Uint16(header[4:6])
+	if version < MinProtocolVersion {
+		return Message{}, fmt.Errorf("unsupported protocol version %d", version)
+	}
+	command := string(bytes.TrimRight(header[6:6+commandSize], "\x00"))
+	limit, known := maxPayloads[command]
+	if !known {
+		return Message{}, fmt.Errorf("%w: %q", ErrUnknownCommand, command)
+	}
+	length := binary.BigEndian.Uint32(header[18:22])
+	if length > limit {
+		return Message{}, fmt.Errorf("%w: %s payload of %d bytes", ErrPayloadTooLarge, command, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return Message{}, err
+	}
+	if !bytes.Equal(checksum(payload), header[22:26]) {
+		return Message{}, ErrBadChecksum
+	}
+	return Message{Command: command, Version: version, Payload: payload}, nil
+}
+
+func checksum(data []byte) []byte {
+	hash := sha256.Sum256(data)
+	return hash[:checksumSize]
+}
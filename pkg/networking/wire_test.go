@@ -0,0 +1,129 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// errAny in a test table accepts any non-nil error
+var errAny = errors.New("any error")
+
+func TestEncodeMessageRoundTrip(t *testing.T) {
+	frame, err := EncodeMessage(CmdPing, Ping{Nonce: 42})
+	if err != nil {
+		t.Fatalf("EncodeMessage: %v", err)
+	}
+	message, err := ReadMessage(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if message.Command != CmdPing || message.Version != ProtocolVersion {
+		t.Errorf("got command %q version %d, want %q version %d", message.Command, message.Version, CmdPing, ProtocolVersion)
+	}
+	var ping Ping
+	if err := message.Decode(&ping); err != nil || ping.Nonce != 42 {
+		t.Errorf("Decode = %+v, %v, want nonce 42", ping, err)
+	}
+}
+
+func TestEncodeMessageLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		payload interface{}
+		want    error
+	}{
+		{"within limit", CmdAddr, Addr{Addresses: []string{"127.0.0.1:3000"}}, nil},
+		{"unknown command", "bogus", Ping{}, ErrUnknownCommand},
+		{"over command limit", CmdPing, Addr{Addresses: []string{strings.Repeat("a", 100)}}, ErrPayloadTooLarge},
+		{"tx over limit", CmdTx, strings.Repeat("a", 64<<10), ErrPayloadTooLarge},
+		{"block over max payload", CmdBlock, strings.Repeat("a", MaxPayloadSize), ErrPayloadTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := EncodeMessage(test.command, test.payload)
+			if !errors.Is(err, test.want) {
+				t.Errorf("EncodeMessage = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+// frame builds a raw frame, bypassing the checks in EncodeMessage
+func frame(magic uint32, version uint16, command string, length uint32, sum []byte, payload []byte) []byte {
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], magic)
+	binary.BigEndian.PutUint16(header[4:6], version)
+	copy(header[6:6+commandSize], command)
+	binary.BigEndian.PutUint32(header[18:22], length)
+	copy(header[22:26], sum)
+	return append(header, payload...)
+}
+
+func TestReadMessageLimits(t *testing.T) {
+	payload := []byte(`{"nonce":1}`)
+	good := checksum(payload)
+	bad := []byte{good[0] ^ 0xff, good[1], good[2], good[3]}
+
+	tests := []struct {
+		name  string
+		frame []byte
+		want  error
+	}{
+		{"valid", frame(NetworkMagic, ProtocolVersion, CmdPing, uint32(len(payload)), good, payload), nil},
+		{"bad magic", frame(0xdeadbeef, ProtocolVersion, CmdPing, uint32(len(payload)), good, payload), ErrBadMagic},
+		{"bad checksum", frame(NetworkMagic, ProtocolVersion, CmdPing, uint32(len(payload)), bad, payload), ErrBadChecksum},
+		{"unknown command", frame(NetworkMagic, ProtocolVersion, "bogus", uint32(len(payload)), good, payload), ErrUnknownCommand},
+		{"over command limit", frame(NetworkMagic, ProtocolVersion, CmdPing, 65, nil, nil), ErrPayloadTooLarge},
+		{"over max payload", frame(NetworkMagic, ProtocolVersion, CmdBlock, MaxPayloadSize+1, nil, nil), ErrPayloadTooLarge},
+		{"old version", frame(NetworkMagic, MinProtocolVersion-1, CmdPing, uint32(len(payload)), good, payload), errAny},
+		{"truncated header", frame(NetworkMagic, ProtocolVersion, CmdPing, uint32(len(payload)), good, payload)[:10], io.ErrUnexpectedEOF},
+		{"truncated payload", frame(NetworkMagic, ProtocolVersion, CmdPing, uint32(len(payload)), good, payload[:3]), io.ErrUnexpectedEOF},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ReadMessage(bytes.NewReader(test.frame))
+			switch {
+			case test.want == errAny:
+				if err == nil {
+					t.Errorf("ReadMessage succeeded, want an error")
+				}
+			case !errors.Is(err, test.want):
+				t.Errorf("ReadMessage = %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeListLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		payload interface{}
+		out     interface{}
+	}{
+		{"inv", CmdInv, Inv{Items: make([]InvItem, MaxInvItems+1)}, &Inv{}},
+		{"getdata", CmdGetData, GetData{Items: make([]InvItem, MaxInvItems+1)}, &GetData{}},
+		{"getheaders", CmdGetHeaders, GetHeaders{Locator: make([]string, MaxLocatorHashes+1)}, &GetHeaders{}},
+		{"addr", CmdAddr, Addr{Addresses: make([]string, MaxAddrItems+1)}, &Addr{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			frame, err := EncodeMessage(test.command, test.payload)
+			if err != nil {
+				t.Fatalf("EncodeMessage: %v", err)
+			}
+			message, err := ReadMessage(bytes.NewReader(frame))
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			if err := message.Decode(test.out); !errors.Is(err, ErrPayloadTooLarge) {
+				t.Errorf("Decode = %v, want %v", err, ErrPayloadTooLarge)
+			}
+		})
+	}
+}
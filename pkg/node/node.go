@@ -3,32 +3,287 @@ package node
 
 import (
 	// "encoding/json"
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/Wraitheon/blockchain-assignment/pkg/api"
 	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+	"github.com/Wraitheon/blockchain-assignment/pkg/chainsync"
+	"github.com/Wraitheon/blockchain-assignment/pkg/consensus"
 	"github.com/Wraitheon/blockchain-assignment/pkg/ipfs"
+	"github.com/Wraitheon/blockchain-assignment/pkg/networking"
+	"github.com/Wraitheon/blockchain-assignment/pkg/relay"
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
 )
 
+// ShutdownTimeout bounds how long Stop waits for API requests in progress
+const ShutdownTimeout = 5 * time.Second
+
+// Config selects where a node keeps its data and which services it runs
+type Config struct {
+	DataDir       string
+	IPFSGateway   string
+	TempDir       string
+	ListenAddress string   // accepts peer connections on host:port
+	Seeds         []string // bootstrap peers for discovery
+	Connect       []string // peers that are always kept connected
+	OutboundPeers int      // outbound connections discovery maintains
+	Limits        networking.PeerLimits
+	APIAddress    string        // serves the HTTP API if set
+	MineInterval  time.Duration // mines pending transactions this often if set
+
+	CheckpointFile string // signed checkpoint list to enforce, if set
+	CheckpointKey  string // hex ed25519 public key the checkpoint list is signed with
+	FinalityDepth  int    // confirmations after which reorgs are refused; 0 disables
+	PruneDepth     int    // keeps block bodies this far below the tip; 0 keeps all
+}
+
+// DefaultConfig returns the configuration of a node without peers or API
+func DefaultConfig() Config {
+	return Config{
+		DataDir:       "Genesis Block",
+		IPFSGateway:   "http://localhost:5001",
+		TempDir:       "temp",
+		OutboundPeers: networking.DefaultOutboundPeers,
+		Limits:        networking.DefaultPeerLimits(),
+		FinalityDepth: blockchain.DefaultFinalityDepth,
+	}
+}
+
 // Node represents a blockchain node
 type Node struct {
 	Blockchain *blockchain.Blockchain
+	Consensus  *consensus.Consensus
 	IPFSClient *ipfs.IPFSClient
+	DataDir    string
 	TempDir    string
+	Key        ed25519.PrivateKey // signs transactions created by this node
+	Config     Config
+
+	// Services, set by Start
+	Network   *networking.PeerManager
+	Syncer    *chainsync.Syncer
+	Relay     *relay.Relay
+	Addresses *networking.AddressManager
+	Discovery *networking.Discovery
+	API       *api.Server // nil unless Config.APIAddress is set
+
+	mu       sync.Mutex
+	started  bool
+	stop     chan struct{} // closed by Stop to stop background work
+	stopOnce sync.Once
+	stopErr  error
+	wg       sync.WaitGroup
+}
+
+// NewNode initializes a node using the default data directory
+func NewNode(ipfsGateway, tempDir string) (*Node, error) {
+	config := DefaultConfig()
+	config.IPFSGateway = ipfsGateway
+	config.TempDir = tempDir
+	return NewNodeWithConfig(config)
 }
 
-// NewNode initializes a new node
-func NewNode(ipfsGateway, tempDir string) *Node {
-	bc := blockchain.NewBlockchain("Genesis Block") // Provide a genesis block
-	client := ipfs.NewIPFSClient(ipfsGateway)
+// NewNodeWithConfig opens the chain, key and mempool in config.DataDir. No
+// services run until Start is called.
+func NewNodeWithConfig(config Config) (*Node, error) {
+	bc, err := blockchain.NewBlockchain(config.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	bc.SetFinalityDepth(config.FinalityDepth)
+	bc.SetPruneDepth(config.PruneDepth) // after the finality depth, which bounds it
+	if config.CheckpointFile != "" {
+		checkpoints, err := blockchain.LoadCheckpoints(config.CheckpointFile, config.CheckpointKey)
+		if err == nil {
+			err = bc.SetCheckpoints(checkpoints)
+		}
+		if err != nil {
+			bc.Close()
+			return nil, err
+		}
+		log.Printf("Loaded %d checkpoints from %s", len(checkpoints), config.CheckpointFile)
+	}
+	client := ipfs.NewIPFSClient(config.IPFSGateway)
+
+	key, err := blockchain.LoadOrCreateKey(filepath.Join(config.DataDir, "node.key"))
+	if err != nil {
+		log.Printf("Failed to load node key, using a temporary one: %v", err)
+		_, key, _ = ed25519.GenerateKey(nil)
+	}
+
+	cons := consensus.NewConsensus(bc, storage.NewMempool(), bc.Difficulty(), nil)
+	cons.Coinbase = blockchain.AddressFromKey(key)
+	if _, err := cons.LoadMempool(config.DataDir); err != nil {
+		log.Printf("Starting with an empty mempool: %v", err)
+	}
+
 	return &Node{
 		Blockchain: bc,
+		Consensus:  cons,
 		IPFSClient: client,
-		TempDir:    tempDir,
+		DataDir:    config.DataDir,
+		TempDir:    config.TempDir,
+		Key:        key,
+		Config:     config,
+		stop:       make(chan struct{}),
+	}, nil
+}
+
+// Start runs the networking, sync, relay, discovery, mempool persistence and,
+// if configured, API and mining services. They run until ctx is cancelled or
+// Stop is called; Stop must be called either way to wait for them and flush
+// storage.
+func (n *Node) Start(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.started {
+		return errors.New("node already started")
+	}
+	n.started = true
+
+	// Announce our chain in handshakes and refuse hosts banned in earlier runs
+	local := networking.DefaultLocalInfo()
+	local.BestHeight = n.Blockchain.Height
+	local.ListenAddress = n.Config.ListenAddress
+	local.Key = n.Key
+	if n.Blockchain.PrunedHeight() > 0 {
+		local.Services &^= networking.ServiceFullBlocks
+	}
+	bans := networking.NewBanList(n.DataDir)
+	n.Network = networking.NewPeerManagerWithConfig(local, n.Config.Limits, bans)
+	n.Consensus.Network = n.Network
+
+	n.Syncer = chainsync.NewSyncer(n.Consensus, n.Network)
+	n.Relay = relay.NewRelay(n.Consensus, n.Network, n.Syncer)
+	discoveryConfig := networking.DefaultDiscoveryConfig()
+	discoveryConfig.Seeds = n.Config.Seeds
+	discoveryConfig.Connect = n.Config.Connect
+	discoveryConfig.OutboundPeers = n.Config.OutboundPeers
+	n.Addresses = networking.NewAddressManager(n.DataDir)
+	n.Discovery = networking.NewDiscovery(n.Network, n.Addresses, discoveryConfig)
+
+	if err := networking.StartServer(n.Config.ListenAddress, n.Network, n.handleMessage); err != nil {
+		return err
+	}
+
+	n.run(n.Syncer.Run)
+	n.run(n.Relay.Run)
+	n.run(n.Network.RunAnnouncements)
+	n.run(n.Discovery.Run) // dial peers once their messages can be handled
+	n.run(func(stop <-chan struct{}) {
+		n.Consensus.PersistMempool(n.DataDir, consensus.MempoolSaveInterval, stop)
+	})
+
+	// Announce transactions entering the mempool to peers
+	n.run(func(stop <-chan struct{}) {
+		n.Network.GossipTransactions(n.Consensus.Mempool, stop)
+	})
+
+	if n.Config.APIAddress != "" {
+		n.API = api.NewServer(n.Consensus)
+		n.API.Syncer = n.Syncer
+		n.API.Network = n.Network
+		n.API.Addresses = n.Addresses
+		go func() {
+			if err := n.API.ListenAndServe(n.Config.APIAddress); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("API server stopped: %v", err)
+			}
+		}()
+	}
+	if n.Config.MineInterval > 0 {
+		n.run(n.mine)
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			n.Stop()
+		case <-n.stop:
+		}
+	}()
+	return nil
+}
+
+// run starts a service that returns once stop is closed
+func (n *Node) run(service func(stop <-chan struct{})) {
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		service(n.stop)
+	}()
+}
+
+// handleMessage passes a peer message to the first service that consumes it
+func (n *Node) handleMessage(peer string, message networking.Message) {
+	if n.Syncer.HandleMessage(peer, message) || n.Relay.HandleMessage(peer, message) ||
+		n.Discovery.HandleMessage(peer, message) {
+		return
+	}
+	log.Printf("Received %s message from %s (%d bytes)", message.Command, peer, len(message.Payload))
+}
+
+// mine mines pending transactions every MineInterval until stop is closed
+func (n *Node) mine(stop <-chan struct{}) {
+	ticker := time.NewTicker(n.Config.MineInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if n.Consensus.Mempool.Len() > 0 {
+				n.Consensus.MineBlock()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Stop shuts the services down, drains peer connections, saves the address
+// book and mempool and closes the blockchain. It is safe to call more than
+// once and without Start; later calls return the first call's result.
+func (n *Node) Stop() error {
+	n.stopOnce.Do(func() {
+		n.stopErr = n.shutdown()
+	})
+	return n.stopErr
+}
+
+func (n *Node) shutdown() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	close(n.stop)
+
+	if n.API != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		if err := n.API.Shutdown(ctx); err != nil {
+			log.Printf("Failed to stop API server cleanly: %v", err)
+		}
+		cancel()
+	}
+	if n.Network != nil {
+		n.Network.Shutdown()
+	}
+	n.wg.Wait()
+
+	if n.Addresses != nil {
+		if err := n.Addresses.Save(); err != nil {
+			log.Printf("Failed to save address book: %v", err)
+		}
+	}
+	if err := n.Consensus.Mempool.Save(n.DataDir); err != nil {
+		log.Printf("Failed to save mempool: %v", err)
 	}
+	return n.Blockchain.Close()
 }
 
 // DownloadRequiredFiles fetches and saves required files from IPFS
@@ -98,7 +353,7 @@ func (n *Node) DownloadRequiredFiles(configCID, algorithmCID, folderCID string)
 
 	algorithmResult, err := n.SolveAlgorithm()
 	if err != nil {
-		log.Fatalf("Error processing dataset: %v", err)
+		return blockchain.Transaction{}, fmt.Errorf("error processing dataset: %v", err)
 	}
 
 	// fmt.Printf(algorithmResult)
@@ -118,6 +373,7 @@ func (n *Node) DownloadRequiredFiles(configCID, algorithmCID, folderCID string)
 
 	n.DeleteTempDir()
 	transaction := blockchain.NewTransaction(string(algorithmContent), string(datasetContent), algorithmResult)
+	transaction.Sign(n.Key, n.Blockchain.NextNonce(blockchain.AddressFromKey(n.Key)))
 
 	return transaction, nil
 }
@@ -126,13 +382,13 @@ func (n *Node) DownloadRequiredFiles(configCID, algorithmCID, folderCID string)
 func (n *Node) SolveAlgorithm() (string, error) {
 	fullPath, err := filepath.Abs(filepath.Join(n.TempDir, "algorithm.go"))
 	if err != nil {
-		log.Fatalf("Error getting absolute path: %v", err)
+		return "", fmt.Errorf("error getting absolute path: %v", err)
 	}
 	log.Printf("Full absolute path to algorithm: %s", fullPath)
 
 	cwd, err := os.Getwd()
 	if err != nil {
-		log.Fatalf("Error getting current directory: %v", err)
+		return "", fmt.Errorf("error getting current directory: %v", err)
 	}
 	log.Printf("Current working directory: %s", cwd)
 
@@ -0,0 +1,303 @@
+package relay
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Wraitheon/blockchain-assignment/pkg/blockchain"
+	"github.com/Wraitheon/blockchain-assignment/pkg/chainsync"
+	"github.com/Wraitheon/blockchain-assignment/pkg/consensus"
+	"github.com/Wraitheon/blockchain-assignment/pkg/networking"
+	"github.com/Wraitheon/blockchain-assignment/pkg/storage"
+)
+
+const (
+	// RequestTimeout is how long an announcing peer has to deliver an item
+	// before it may be requested from another peer
+	RequestTimeout = 30 * time.Second
+	// TickInterval is how often Run expires requests
+	TickInterval = 5 * time.Second
+
+	// Per-peer limits on what is accepted; excess messages are dropped
+	InvItemsPerSecond     = 1000
+	InvItemsBurst         = 5000
+	TransactionsPerSecond = 50
+	TransactionsBurst     = 200
+	BlocksPerSecond       = 2
+	BlocksBurst           = 16
+)
+
+// peerLimits holds the rate limiters for one peer
+type peerLimits struct {
+	inv    *networking.RateLimiter
+	tx     *networking.RateLimiter
+	blocks *networking.RateLimiter
+}
+
+func newPeerLimits() *peerLimits {
+	return &peerLimits{
+		inv:    networking.NewRateLimiter(InvItemsPerSecond, InvItemsBurst),
+		tx:     networking.NewRateLimiter(TransactionsPerSecond, TransactionsBurst),
+		blocks: networking.NewRateLimiter(BlocksPerSecond, BlocksBurst),
+	}
+}
+
+// Relay exchanges transactions and blocks with peers by inventory: peers
+// announce hashes with inv, the receiver fetches what it lacks with getdata,
+// and whatever validates is announced onward. Each peer's known inventory
+// keeps items from being announced back to where they came from.
+type Relay struct {
+	Consensus *consensus.Consensus
+	Network   *networking.PeerManager
+	Syncer    *chainsync.Syncer // told about peers announcing new blocks; may be nil
+
+	mu        sync.Mutex
+	requested map[networking.InvItem]time.Time // items asked for with getdata
+	limits    map[string]*peerLimits
+}
+
+// NewRelay creates a relay feeding items from network into cons
+func NewRelay(cons *consensus.Consensus, network *networking.PeerManager, syncer *chainsync.Syncer) *Relay {
+	return &Relay{
+		Consensus: cons,
+		Network:   network,
+		Syncer:    syncer,
+		requested: make(map[networking.InvItem]time.Time),
+		limits:    make(map[string]*peerLimits),
+	}
+}
+
+// Run expires outstanding requests until stop is closed
+func (r *Relay) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(TickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.tick()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// HandleMessage processes inv, getdata, tx, block and reject messages and
+// reports whether the message was consumed. Blocks requested through getdata
+// are served by the syncer, which must see messages first.
+func (r *Relay) HandleMessage(peer string, message networking.Message) bool {
+	switch message.Command {
+	case networking.CmdInv:
+		var inv networking.Inv
+		if err := message.Decode(&inv); err != nil {
+			r.Network.Misbehaving(peer, networking.PayloadScore(err), err.Error())
+			return true
+		}
+		if !r.peerLimits(peer).inv.Allow(len(inv.Items)) {
+			r.Network.Misbehaving(peer, networking.ScoreSpam, "inv rate limit exceeded")
+			return true
+		}
+		r.handleInv(peer, inv.Items)
+		return true
+
+	case networking.CmdGetData:
+		var request networking.GetData
+		if err := message.Decode(&request); err != nil {
+			r.Network.Misbehaving(peer, networking.PayloadScore(err), err.Error())
+			return true
+		}
+		r.serveTransactions(peer, request.Items)
+		return true
+
+	case networking.CmdTx:
+		if !r.peerLimits(peer).tx.Allow(1) {
+			r.Network.Misbehaving(peer, networking.ScoreSpam, "transaction rate limit exceeded")
+			return true
+		}
+		var tx blockchain.Transaction
+		if err := message.Decode(&tx); err != nil {
+			r.Network.Misbehaving(peer, networking.PayloadScore(err), err.Error())
+			return true
+		}
+		r.handleTransaction(peer, tx)
+		return true
+
+	case networking.CmdBlock:
+		if !r.peerLimits(peer).blocks.Allow(1) {
+			// Not scored: the block may answer one of our orphan requests,
+			// and proof of work already makes block spam expensive
+			log.Printf("Dropping block from %s: rate limit exceeded", peer)
+			return true
+		}
+		var block blockchain.Block
+		if err := message.Decode(&block); err != nil {
+			r.Network.Misbehaving(peer, networking.PayloadScore(err), err.Error())
+			return true
+		}
+		r.handleBlock(peer, block)
+		return true
+
+	case networking.CmdReject:
+		var reject networking.Reject
+		if err := message.Decode(&reject); err != nil {
+			r.Network.Misbehaving(peer, networking.PayloadScore(err), err.Error())
+			return true
+		}
+		log.Printf("Peer %s rejected transaction %s (%s): %s", peer, reject.TxID, reject.Code, reject.Reason)
+		return true
+	}
+	return false
+}
+
+// handleInv requests the announced items we neither have nor already asked
+// another peer for
+func (r *Relay) handleInv(peer string, items []networking.InvItem) {
+	r.Network.MarkKnown(peer, items...)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var wanted []networking.InvItem
+	for _, item := range items {
+		if _, pending := r.requested[item]; pending || r.have(item) {
+			continue
+		}
+		r.requested[item] = time.Now()
+		wanted = append(wanted, item)
+	}
+	if len(wanted) == 0 {
+		return
+	}
+	if err := r.Network.Send(peer, networking.CmdGetData, networking.GetData{Items: wanted}); err != nil {
+		log.Printf("Failed to request items from %s: %v", peer, err)
+		for _, item := range wanted {
+			delete(r.requested, item)
+		}
+	}
+}
+
+// have reports whether item is already pending, confirmed or otherwise known
+func (r *Relay) have(item networking.InvItem) bool {
+	switch item.Type {
+	case networking.InvTx:
+		if r.Consensus.Mempool.Has(item.Hash) {
+			return true
+		}
+		_, err := r.Consensus.Blockchain.GetTransaction(item.Hash)
+		return err == nil
+	case networking.InvBlock:
+		return r.Consensus.HasBlock(item.Hash)
+	}
+	return true // unknown types are never requested
+}
+
+// serveTransactions answers the transaction entries of a getdata request from
+// the mempool
+func (r *Relay) serveTransactions(peer string, items []networking.InvItem) {
+	for _, item := range items {
+		if item.Type != networking.InvTx {
+			continue
+		}
+		tx, exists := r.Consensus.Mempool.Get(item.Hash)
+		if !exists {
+			continue
+		}
+		if err := r.Network.Send(peer, networking.CmdTx, tx); err != nil {
+			log.Printf("Failed to send transaction to %s: %v", peer, err)
+			return
+		}
+		r.Network.MarkKnown(peer, item)
+	}
+}
+
+// handleTransaction admits a transaction from peer to the mempool, which
+// announces it onward, or tells the peer why it was refused
+func (r *Relay) handleTransaction(peer string, tx blockchain.Transaction) {
+	item := networking.InvItem{Type: networking.InvTx, Hash: tx.ID()}
+	r.Network.MarkKnown(peer, item)
+	r.finishRequest(item)
+	if r.Consensus.Mempool.Has(item.Hash) {
+		return
+	}
+
+	err := r.Consensus.VerifyAndAddTransaction(tx)
+	if err == nil {
+		return
+	}
+	code, ok := storage.RejectCodeOf(err)
+	if !ok || code == storage.RejectDuplicate {
+		return
+	}
+	if code == storage.RejectInvalid || code == storage.RejectMalformed {
+		r.Network.Misbehaving(peer, networking.ScoreInvalidTx, err.Error())
+	}
+	reject := networking.Reject{TxID: item.Hash, Code: string(code), Reason: err.Error()}
+	if err := r.Network.SendReject(peer, reject); err != nil {
+		log.Printf("Failed to send reject to %s: %v", peer, err)
+	}
+}
+
+// handleBlock connects a block from peer and announces it onward if it was
+// accepted. Blocks without valid work are refused before they can reach the
+// orphan pool.
+func (r *Relay) handleBlock(peer string, block blockchain.Block) {
+	item := networking.InvItem{Type: networking.InvBlock, Hash: block.Hash}
+	r.Network.MarkKnown(peer, item)
+	r.finishRequest(item)
+	if block.Hash != block.CalculateHash() || !block.MeetsDifficulty(r.Consensus.Blockchain.Difficulty()) {
+		r.Network.Misbehaving(peer, networking.ScoreBadWork, "block "+block.Hash+" has invalid proof of work")
+		return
+	}
+	if r.Syncer != nil {
+		// A block far ahead of us means the peer's chain is worth syncing
+		r.Syncer.NotePeerHeight(peer, block.Index)
+	}
+	if r.Consensus.VerifyAndAddBlock(block, peer) {
+		r.Consensus.BroadcastBlock(block)
+		return
+	}
+	// A block whose parent we have but that was neither connected nor stored
+	// failed validation, as did one marked invalid by a failed reorganization
+	if r.Consensus.IsInvalid(block.Hash) ||
+		(r.Consensus.HasBlock(block.PrevHash) && !r.Consensus.HasBlock(block.Hash)) {
+		r.Network.Misbehaving(peer, networking.ScoreInvalidBlock, "invalid block "+block.Hash)
+	}
+}
+
+func (r *Relay) finishRequest(item networking.InvItem) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.requested, item)
+}
+
+func (r *Relay) peerLimits(peer string) *peerLimits {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	limits, exists := r.limits[peer]
+	if !exists {
+		limits = newPeerLimits()
+		r.limits[peer] = limits
+	}
+	return limits
+}
+
+// tick forgets requests that were never answered and peers that left
+func (r *Relay) tick() {
+	connected := make(map[string]bool)
+	for _, peer := range r.Network.Peers() {
+		connected[peer] = true
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	expiry := time.Now().Add(-RequestTimeout)
+	for item, requested := range r.requested {
+		if requested.Before(expiry) {
+			delete(r.requested, item)
+		}
+	}
+	for peer := range r.limits {
+		if !connected[peer] {
+			delete(r.limits, peer)
+		}
+	}
+}
@@ -0,0 +1,574 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	blockLogFile     = "blocks.log"
+	blockCompactFile = "blocks.log.compact"
+	blockIndexFile   = "index.db"
+
+	// recordHeaderSize covers the length and checksum fields
+	recordHeaderSize = 4 + 4
+	// maxRecordSize rejects absurd lengths read from a corrupted log
+	maxRecordSize = 64 << 20
+)
+
+var (
+	heightBucket  = []byte("heights") // height -> location of the record
+	hashBucket    = []byte("hashes")  // block hash -> height
+	metaBucket    = []byte("meta")
+	compactingKey = []byte("compacting") // set while a compacted log is being swapped in
+
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// ErrBlockNotFound is returned when no block is stored for a height or hash
+	ErrBlockNotFound = errors.New("block not found")
+	// ErrDataDirInUse is returned when another process, such as a running
+	// node, holds the databases in the data directory
+	ErrDataDirInUse = errors.New("data directory is in use by another process")
+)
+
+// lockTimeout bounds the wait for a database held by another process
+const lockTimeout = time.Second
+
+// openDB opens a bbolt database, failing with ErrDataDirInUse instead of
+// waiting for a database another process holds
+func openDB(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: lockTimeout})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, ErrDataDirInUse
+	}
+	return db, err
+}
+
+// BlockStore is an append-only, checksummed block log with a height and hash
+// index kept in an embedded bbolt database. Each record is fsynced before it
+// is indexed, and a torn tail left by a crash is discarded on open.
+//
+// Record layout: length (u32) | crc32c (u32) | height (u64) | hashLen (u16) | hash | data
+// where length and the checksum both cover everything after the checksum.
+type BlockStore struct {
+	mu     sync.Mutex
+	dir    string
+	log    *os.File
+	index  *bolt.DB
+	logEnd int64
+	count  uint64 // number of indexed blocks; the tip height is count-1
+}
+
+type recordLocation struct {
+	Offset int64
+	Length uint32
+	Hash   string
+}
+
+// OpenBlockStore opens or creates the block store in dataDir and recovers
+// from any incomplete write
+func OpenBlockStore(dataDir string) (*BlockStore, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+
+	index, err := openDB(filepath.Join(dataDir, blockIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open block index: %w", err)
+	}
+	if err := finishCompaction(dataDir, index); err != nil {
+		index.Close()
+		return nil, err
+	}
+	logFile, err := os.OpenFile(filepath.Join(dataDir, blockLogFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		index.Close()
+		return nil, fmt.Errorf("failed to open block log: %v", err)
+	}
+
+	bs := &BlockStore{dir: dataDir, log: logFile, index: index}
+	if err := bs.recover(); err != nil {
+		bs.Close()
+		return nil, err
+	}
+	return bs, nil
+}
+
+// Close releases the log file and the index
+func (bs *BlockStore) Close() error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	errLog := bs.log.Close()
+	errIndex := bs.index.Close()
+	if errLog != nil {
+		return errLog
+	}
+	return errIndex
+}
+
+// Count returns the number of stored blocks
+func (bs *BlockStore) Count() uint64 {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.count
+}
+
+// Append durably stores the block at the next height
+func (bs *BlockStore) Append(height uint64, hash string, data []byte) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if height != bs.count {
+		return fmt.Errorf("cannot append height %d, next height is %d", height, bs.count)
+	}
+
+	record := encodeRecord(height, hash, data)
+	if _, err := bs.log.WriteAt(record, bs.logEnd); err != nil {
+		return fmt.Errorf("failed to write block record: %v", err)
+	}
+	if err := bs.log.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block log: %v", err)
+	}
+
+	loc := recordLocation{Offset: bs.logEnd, Length: uint32(len(record)), Hash: hash}
+	err := bs.index.Update(func(tx *bolt.Tx) error {
+		return putLocation(tx, height, loc)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index block: %v", err)
+	}
+
+	bs.logEnd += int64(len(record))
+	bs.count++
+	return nil
+}
+
+// Get returns the data stored for height
+func (bs *BlockStore) Get(height uint64) ([]byte, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	var loc recordLocation
+	var found bool
+	err := bs.index.View(func(tx *bolt.Tx) error {
+		loc, found = getLocation(tx, height)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrBlockNotFound
+	}
+	return bs.readRecord(loc, height)
+}
+
+// GetByHash returns the data stored for the block with the given hash
+func (bs *BlockStore) GetByHash(hash string) ([]byte, error) {
+	height, err := bs.HeightOf(hash)
+	if err != nil {
+		return nil, err
+	}
+	return bs.Get(height)
+}
+
+// HeightOf returns the height of the block with the given hash
+func (bs *BlockStore) HeightOf(hash string) (uint64, error) {
+	var height uint64
+	var found bool
+	err := bs.index.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(hashBucket).Get([]byte(hash))
+		if value != nil {
+			height, found = binary.BigEndian.Uint64(value), true
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, ErrBlockNotFound
+	}
+	return height, nil
+}
+
+// Truncate removes every block above height. The log is cut first so that a
+// crash before the index update is repaired by recover.
+func (bs *BlockStore) Truncate(height uint64) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if height+1 >= bs.count {
+		return nil
+	}
+
+	var newEnd int64
+	err := bs.index.View(func(tx *bolt.Tx) error {
+		loc, found := getLocation(tx, height)
+		if !found {
+			return fmt.Errorf("no block indexed at height %d", height)
+		}
+		newEnd = loc.Offset + int64(loc.Length)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := bs.log.Truncate(newEnd); err != nil {
+		return fmt.Errorf("failed to truncate block log: %v", err)
+	}
+	if err := bs.log.Sync(); err != nil {
+		return fmt.Errorf("failed to sync block log: %v", err)
+	}
+	if err := bs.dropIndexAbove(height); err != nil {
+		return err
+	}
+
+	bs.logEnd = newEnd
+	bs.count = height + 1
+	return nil
+}
+
+// Compact rewrites the log, replacing the data of every block up to and
+// including height with the result of rewrite. It is used to drop pruned
+// block bodies. The new log is fully written and synced, the index is
+// switched to it in a single transaction marked as compacting, and only then
+// is the new log renamed into place; OpenBlockStore completes an interrupted
+// swap.
+func (bs *BlockStore) Compact(height uint64, rewrite func(height uint64, data []byte) []byte) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	compactPath := filepath.Join(bs.dir, blockCompactFile)
+	out, err := os.OpenFile(compactPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create compacted log: %v", err)
+	}
+	defer out.Close()
+
+	locations := make([]recordLocation, bs.count)
+	var offset int64
+	for h := uint64(0); h < bs.count; h++ {
+		var loc recordLocation
+		bs.index.View(func(tx *bolt.Tx) error {
+			loc, _ = getLocation(tx, h)
+			return nil
+		})
+		data, err := bs.readRecord(loc, h)
+		if err != nil {
+			os.Remove(compactPath)
+			return err
+		}
+		if h <= height {
+			data = rewrite(h, data)
+		}
+		record := encodeRecord(h, loc.Hash, data)
+		if _, err := out.WriteAt(record, offset); err != nil {
+			os.Remove(compactPath)
+			return fmt.Errorf("failed to write compacted log: %v", err)
+		}
+		locations[h] = recordLocation{Offset: offset, Length: uint32(len(record)), Hash: loc.Hash}
+		offset += int64(len(record))
+	}
+	if err := out.Sync(); err != nil {
+		os.Remove(compactPath)
+		return fmt.Errorf("failed to sync compacted log: %v", err)
+	}
+
+	err = bs.index.Update(func(tx *bolt.Tx) error {
+		for h, loc := range locations {
+			if err := putLocation(tx, uint64(h), loc); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(metaBucket).Put(compactingKey, []byte{1})
+	})
+	if err != nil {
+		os.Remove(compactPath)
+		return fmt.Errorf("failed to index compacted log: %v", err)
+	}
+
+	bs.log.Close()
+	if err := finishCompaction(bs.dir, bs.index); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(filepath.Join(bs.dir, blockLogFile), os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen block log: %v", err)
+	}
+	bs.log = logFile
+	bs.logEnd = offset
+	return nil
+}
+
+// finishCompaction completes or rolls back a compaction interrupted by a
+// crash. If the index was already switched, the compacted log replaces the
+// old one; otherwise the partial compacted log is discarded.
+func finishCompaction(dir string, index *bolt.DB) error {
+	compactPath := filepath.Join(dir, blockCompactFile)
+	var compacting bool
+	err := index.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+		compacting = meta.Get(compactingKey) != nil
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read block index metadata: %v", err)
+	}
+
+	if !compacting {
+		if err := os.Remove(compactPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove partial compacted log: %v", err)
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(compactPath); err == nil {
+		if err := os.Rename(compactPath, filepath.Join(dir, blockLogFile)); err != nil {
+			return fmt.Errorf("failed to swap in compacted log: %v", err)
+		}
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+	return index.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Delete(compactingKey)
+	})
+}
+
+// recover reconciles the index with the log after an unclean shutdown:
+// index entries pointing past the end of the log are dropped, complete
+// records written after the last index update are indexed, and a torn
+// record at the tail is cut off.
+func (bs *BlockStore) recover() error {
+	err := bs.index.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{heightBucket, hashBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to initialize block index: %v", err)
+	}
+
+	info, err := bs.log.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat block log: %v", err)
+	}
+	size := info.Size()
+
+	// Drop index entries for records that no longer exist in the log
+	var count uint64
+	var indexedEnd int64
+	err = bs.index.View(func(tx *bolt.Tx) error {
+		count = uint64(tx.Bucket(heightBucket).Stats().KeyN)
+		for count > 0 {
+			loc, _ := getLocation(tx, count-1)
+			if end := loc.Offset + int64(loc.Length); end <= size {
+				indexedEnd = end
+				break
+			}
+			count--
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		err = bs.dropIndexAbove(count - 1)
+	} else {
+		err = bs.clearIndex()
+	}
+	if err != nil {
+		return err
+	}
+	bs.logEnd = indexedEnd
+	bs.count = count
+
+	// Index complete records appended after the last index update
+	for bs.logEnd < size {
+		height, hash, length, err := bs.scanRecord(bs.logEnd, size)
+		if err != nil || height != bs.count {
+			log.Printf("Discarding %d bytes of incomplete block log at offset %d", size-bs.logEnd, bs.logEnd)
+			break
+		}
+		loc := recordLocation{Offset: bs.logEnd, Length: length, Hash: hash}
+		err = bs.index.Update(func(tx *bolt.Tx) error {
+			return putLocation(tx, height, loc)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to index recovered block: %v", err)
+		}
+		bs.logEnd += int64(length)
+		bs.count++
+	}
+
+	if bs.logEnd < size {
+		if err := bs.log.Truncate(bs.logEnd); err != nil {
+			return fmt.Errorf("failed to truncate torn block log: %v", err)
+		}
+		if err := bs.log.Sync(); err != nil {
+			return fmt.Errorf("failed to sync block log: %v", err)
+		}
+	}
+	return nil
+}
+
+// scanRecord validates the record at offset and returns its height, hash and
+// total length
+func (bs *BlockStore) scanRecord(offset, size int64) (uint64, string, uint32, error) {
+	if size-offset < recordHeaderSize {
+		return 0, "", 0, io.ErrUnexpectedEOF
+	}
+	header := make([]byte, recordHeaderSize)
+	if _, err := bs.log.ReadAt(header, offset); err != nil {
+		return 0, "", 0, err
+	}
+	bodyLength := binary.BigEndian.Uint32(header[0:4])
+	if bodyLength > maxRecordSize || int64(recordHeaderSize)+int64(bodyLength) > size-offset {
+		return 0, "", 0, io.ErrUnexpectedEOF
+	}
+	record := make([]byte, recordHeaderSize+int(bodyLength))
+	if _, err := bs.log.ReadAt(record, offset); err != nil {
+		return 0, "", 0, err
+	}
+	height, hash, _, err := decodeRecord(record)
+	if err != nil {
+		return 0, "", 0, err
+	}
+	return height, hash, uint32(len(record)), nil
+}
+
+func (bs *BlockStore) readRecord(loc recordLocation, height uint64) ([]byte, error) {
+	record := make([]byte, loc.Length)
+	if _, err := bs.log.ReadAt(record, loc.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read block record: %v", err)
+	}
+	recordHeight, _, data, err := decodeRecord(record)
+	if err != nil {
+		return nil, err
+	}
+	if recordHeight != height {
+		return nil, fmt.Errorf("block record at height %d is labelled %d", height, recordHeight)
+	}
+	return data, nil
+}
+
+func (bs *BlockStore) dropIndexAbove(height uint64) error {
+	return bs.index.Update(func(tx *bolt.Tx) error {
+		heights := tx.Bucket(heightBucket)
+		cursor := heights.Cursor()
+		for k, v := cursor.Seek(heightKey(height + 1)); k != nil; k, v = cursor.Next() {
+			loc := decodeLocation(v)
+			if err := tx.Bucket(hashBucket).Delete([]byte(loc.Hash)); err != nil {
+				return err
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (bs *BlockStore) clearIndex() error {
+	return bs.index.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{heightBucket, hashBucket} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func encodeRecord(height uint64, hash string, data []byte) []byte {
+	bodyLength := 8 + 2 + len(hash) + len(data)
+	record := make([]byte, recordHeaderSize+bodyLength)
+	binary.BigEndian.PutUint32(record[0:4], uint32(bodyLength))
+	body := record[8:]
+	binary.BigEndian.PutUint64(body[0:8], height)
+	binary.BigEndian.PutUint16(body[8:10], uint16(len(hash)))
+	copy(body[10:], hash)
+	copy(body[10+len(hash):], data)
+	binary.BigEndian.PutUint32(record[4:8], crc32.Checksum(body, crcTable))
+	return record
+}
+
+func decodeRecord(record []byte) (uint64, string, []byte, error) {
+	if len(record) < recordHeaderSize+10 {
+		return 0, "", nil, io.ErrUnexpectedEOF
+	}
+	body := record[8:]
+	if int(binary.BigEndian.Uint32(record[0:4])) != len(body) {
+		return 0, "", nil, errors.New("block record length mismatch")
+	}
+	if crc32.Checksum(body, crcTable) != binary.BigEndian.Uint32(record[4:8]) {
+		return 0, "", nil, errors.New("block record checksum mismatch")
+	}
+	height := binary.BigEndian.Uint64(body[0:8])
+	hashLength := int(binary.BigEndian.Uint16(body[8:10]))
+	if 10+hashLength > len(body) {
+		return 0, "", nil, errors.New("block record hash out of range")
+	}
+	hash := string(body[10 : 10+hashLength])
+	return height, hash, body[10+hashLength:], nil
+}
+
+func putLocation(tx *bolt.Tx, height uint64, loc recordLocation) error {
+	if err := tx.Bucket(heightBucket).Put(heightKey(height), encodeLocation(loc)); err != nil {
+		return err
+	}
+	return tx.Bucket(hashBucket).Put([]byte(loc.Hash), heightKey(height))
+}
+
+func getLocation(tx *bolt.Tx, height uint64) (recordLocation, bool) {
+	value := tx.Bucket(heightBucket).Get(heightKey(height))
+	if value == nil {
+		return recordLocation{}, false
+	}
+	return decodeLocation(value), true
+}
+
+func encodeLocation(loc recordLocation) []byte {
+	value := make([]byte, 12+len(loc.Hash))
+	binary.BigEndian.PutUint64(value[0:8], uint64(loc.Offset))
+	binary.BigEndian.PutUint32(value[8:12], loc.Length)
+	copy(value[12:], loc.Hash)
+	return value
+}
+
+func decodeLocation(value []byte) recordLocation {
+	return recordLocation{
+		Offset: int64(binary.BigEndian.Uint64(value[0:8])),
+		Length: binary.BigEndian.Uint32(value[8:12]),
+		Hash:   string(value[12:]),
+	}
+}
+
+func heightKey(height uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, height)
+	return key
+}
@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func blockData(height uint64) []byte {
+	return []byte(fmt.Sprintf(`{"index":%d}`, height))
+}
+
+func blockHash(height uint64) string {
+	return fmt.Sprintf("hash%d", height)
+}
+
+// openStoreWith creates a block store in a new directory holding n blocks and
+// closes it, returning the directory
+func openStoreWith(t *testing.T, n uint64) string {
+	t.Helper()
+	dir := t.TempDir()
+	bs, err := OpenBlockStore(dir)
+	if err != nil {
+		t.Fatalf("OpenBlockStore: %v", err)
+	}
+	for height := uint64(0); height < n; height++ {
+		if err := bs.Append(height, blockHash(height), blockData(height)); err != nil {
+			t.Fatalf("Append(%d): %v", height, err)
+		}
+	}
+	if err := bs.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return dir
+}
+
+// appendToLog writes raw bytes to the end of the block log, as a crash
+// between the log write and the index update would leave it
+func appendToLog(t *testing.T, dir string, data []byte) {
+	t.Helper()
+	file, err := os.OpenFile(filepath.Join(dir, blockLogFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func logSize(t *testing.T, dir string) int64 {
+	t.Helper()
+	info, err := os.Stat(filepath.Join(dir, blockLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+// checkBlocks verifies that bs holds exactly n intact blocks
+func checkBlocks(t *testing.T, bs *BlockStore, n uint64) {
+	t.Helper()
+	if bs.Count() != n {
+		t.Fatalf("Count = %d, want %d", bs.Count(), n)
+	}
+	for height := uint64(0); height < n; height++ {
+		data, err := bs.Get(height)
+		if err != nil || string(data) != string(blockData(height)) {
+			t.Errorf("Get(%d) = %q, %v, want %q", height, data, err, blockData(height))
+		}
+		if got, err := bs.HeightOf(blockHash(height)); err != nil || got != height {
+			t.Errorf("HeightOf(%s) = %d, %v, want %d", blockHash(height), got, err, height)
+		}
+	}
+	if _, err := bs.Get(n); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("Get(%d) = %v, want %v", n, err, ErrBlockNotFound)
+	}
+}
+
+func TestBlockStoreRecovery(t *testing.T) {
+	record := encodeRecord(3, blockHash(3), blockData(3))
+	corrupt := append([]byte(nil), record...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		tail []byte // written to the log after three indexed blocks
+		want uint64 // blocks expected after reopening
+	}{
+		{"torn record", record[:len(record)/2], 3},
+		{"torn header", record[:recordHeaderSize-2], 3},
+		{"bad checksum", corrupt, 3},
+		{"wrong height", encodeRecord(7, blockHash(7), blockData(7)), 3},
+		{"unindexed record", record, 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := openStoreWith(t, 3)
+			clean := logSize(t, dir)
+			appendToLog(t, dir, test.tail)
+
+			bs, err := OpenBlockStore(dir)
+			if err != nil {
+				t.Fatalf("OpenBlockStore: %v", err)
+			}
+			defer bs.Close()
+			checkBlocks(t, bs, test.want)
+
+			// The next append overwrites any discarded tail
+			next := test.want
+			if err := bs.Append(next, blockHash(next), blockData(next)); err != nil {
+				t.Fatalf("Append(%d) after recovery: %v", next, err)
+			}
+			checkBlocks(t, bs, next+1)
+			if test.want == 3 && logSize(t, dir) != clean+int64(len(record)) {
+				t.Errorf("log is %d bytes, want the discarded tail replaced", logSize(t, dir))
+			}
+		})
+	}
+}
+
+func TestBlockStoreRecoversIndexAheadOfLog(t *testing.T) {
+	dir := openStoreWith(t, 3)
+	// Lose the last record, as if the log write never reached the disk
+	before := logSize(t, dir)
+	last := int64(len(encodeRecord(2, blockHash(2), blockData(2))))
+	if err := os.Truncate(filepath.Join(dir, blockLogFile), before-last); err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := OpenBlockStore(dir)
+	if err != nil {
+		t.Fatalf("OpenBlockStore: %v", err)
+	}
+	defer bs.Close()
+	checkBlocks(t, bs, 2)
+	if _, err := bs.HeightOf(blockHash(2)); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("HeightOf(%s) = %v, want %v", blockHash(2), err, ErrBlockNotFound)
+	}
+}
+
+func TestBlockStoreReopenAfterRecovery(t *testing.T) {
+	dir := openStoreWith(t, 2)
+	appendToLog(t, dir, []byte{1, 2, 3})
+
+	bs, err := OpenBlockStore(dir)
+	if err != nil {
+		t.Fatalf("OpenBlockStore: %v", err)
+	}
+	if err := bs.Append(2, blockHash(2), blockData(2)); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+	bs.Close()
+
+	bs, err = OpenBlockStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer bs.Close()
+	checkBlocks(t, bs, 3)
+}
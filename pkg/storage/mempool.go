@@ -1,53 +1,488 @@
 package storage
 
 import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 )
 
+const (
+	// DefaultMaxMempoolTxs is the default limit on pending transactions
+	DefaultMaxMempoolTxs = 5000
+	// DefaultMaxMempoolBytes is the default limit on the serialized size of pending transactions
+	DefaultMaxMempoolBytes = 16 << 20
+	// DefaultMempoolTTL is how long a transaction may wait before it expires
+	DefaultMempoolTTL = 24 * time.Hour
+	// MinReplacementFeeBump is the percentage by which a replacement must
+	// raise the fee of the pending transaction with the same sender nonce
+	MinReplacementFeeBump = 10
+	// MempoolFile is the name of the saved mempool in the data directory
+	MempoolFile = "mempool.json"
+)
+
+// SavedTransaction is a pending transaction as written to disk
+type SavedTransaction struct {
+	Tx    json.RawMessage `json:"tx"`
+	Added time.Time       `json:"added"`
+}
+
 type Transaction interface {
 	Serialize() string // Define the methods needed for a transaction
+	ID() string
+	GetSender() string
+	GetNonce() uint64
+	GetFee() uint64
+}
+
+// MempoolConfig bounds the mempool
+type MempoolConfig struct {
+	MaxTxs   int
+	MaxBytes int
+	TTL      time.Duration
+}
+
+// DefaultMempoolConfig returns the default mempool limits
+func DefaultMempoolConfig() MempoolConfig {
+	return MempoolConfig{
+		MaxTxs:   DefaultMaxMempoolTxs,
+		MaxBytes: DefaultMaxMempoolBytes,
+		TTL:      DefaultMempoolTTL,
+	}
 }
 
+type mempoolEntry struct {
+	tx      Transaction
+	id      string
+	size    int
+	feeRate float64 // fee per serialized byte
+	added   time.Time
+	index   int // position in the eviction heap
+}
+
+// Mempool holds pending transactions, bounded by count and bytes. Entries are
+// indexed by ID and by sender nonce; the lowest fee-rate entries are evicted
+// first and blocks are built from the highest fee rates while keeping each
+// sender's nonces in order.
 type Mempool struct {
-	mu           sync.Mutex
-	transactions []Transaction
+	mu         sync.Mutex
+	config     MempoolConfig
+	entries    map[string]*mempoolEntry
+	bySender   map[string]map[uint64]*mempoolEntry
+	evictQueue evictionHeap
+	totalBytes int
+
+	subscribers []chan MempoolEvent
+	subsMutex   sync.Mutex
+
+	saveMutex sync.Mutex // serializes Save so the last snapshot taken is the one kept
 }
 
 func NewMempool() *Mempool {
+	return NewMempoolWithConfig(DefaultMempoolConfig())
+}
+
+// NewMempoolWithConfig creates a mempool with the given limits
+func NewMempoolWithConfig(config MempoolConfig) *Mempool {
 	return &Mempool{
-		transactions: []Transaction{},
+		config:   config,
+		entries:  make(map[string]*mempoolEntry),
+		bySender: make(map[string]map[uint64]*mempoolEntry),
 	}
 }
 
-func (m *Mempool) AddTransaction(tx Transaction) {
+// AddTransaction adds tx to the pool. A transaction using the same sender
+// nonce as a pending one replaces it only if it raises the fee by at least
+// MinReplacementFeeBump percent. When the pool is over its limits the lowest
+// fee-rate transactions are evicted; if that would include tx itself, tx is
+// refused and the pool, including any transaction it would replace, is left
+// unchanged. Refusals are returned as *RejectError.
+func (m *Mempool) AddTransaction(tx Transaction) error {
+	return m.RestoreTransaction(tx, time.Now())
+}
+
+// RestoreTransaction adds tx as if it had been received at added, so a
+// transaction reloaded from disk keeps its original expiry time
+func (m *Mempool) RestoreTransaction(tx Transaction, added time.Time) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.transactions = append(m.transactions, tx)
+	now := time.Now()
+	m.expireLocked(now)
+	if m.config.TTL > 0 && now.Sub(added) > m.config.TTL {
+		return Reject(RejectExpired, "transaction %s has expired", tx.ID())
+	}
+
+	id := tx.ID()
+	if _, exists := m.entries[id]; exists {
+		return Reject(RejectDuplicate, "transaction %s is already pending", id)
+	}
+	// Entries pushed out by tx are detached first and only evicted once tx
+	// is known to fit, so a refused transaction leaves the pool unchanged
+	var displaced []*mempoolEntry
+	if pending, exists := m.bySender[tx.GetSender()][tx.GetNonce()]; exists {
+		required := replacementFee(pending.tx.GetFee())
+		if tx.GetFee() < required {
+			return Reject(RejectInsufficient, "replacing %s requires a fee of at least %d, got %d", pending.id, required, tx.GetFee())
+		}
+		m.detachLocked(pending)
+		displaced = append(displaced, pending)
+	}
+
+	size := len(tx.Serialize())
+	entry := &mempoolEntry{
+		tx:      tx,
+		id:      id,
+		size:    size,
+		feeRate: float64(tx.GetFee()) / float64(size),
+		added:   added,
+	}
+	m.insertLocked(entry)
+
+	for m.overLimitsLocked() && m.entries[id] == entry {
+		for _, victim := range m.withDescendantsLocked(m.evictQueue[0]) {
+			m.detachLocked(victim)
+			displaced = append(displaced, victim)
+		}
+	}
+	if m.entries[id] != entry {
+		for _, victim := range displaced {
+			if victim != entry {
+				m.insertLocked(victim)
+			}
+		}
+		return Reject(RejectMempoolFull, "fee rate too low to enter a full mempool")
+	}
+
+	for _, victim := range displaced {
+		m.notify(TxEvicted, victim.tx)
+	}
+	// Announce tx only once it has survived eviction
+	m.notify(TxAdded, tx)
+	return nil
 }
 
-func (m *Mempool) RemoveTransaction(tx Transaction) {
+// NextNonce returns the nonce following the sender's pending transactions,
+// counting up from confirmed, the next nonce according to the chain
+func (m *Mempool) NextNonce(sender string, confirmed uint64) uint64 {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for i, t := range m.transactions {
-		if t.Serialize() == tx.Serialize() {
-			m.transactions = append(m.transactions[:i], m.transactions[i+1:]...)
-			break
+	nonce := confirmed
+	for {
+		if _, exists := m.bySender[sender][nonce]; !exists {
+			return nonce
 		}
+		nonce++
+	}
+}
+
+// RemoveTransaction removes tx from the pool
+func (m *Mempool) RemoveTransaction(tx Transaction) {
+	m.Remove(tx.ID())
+}
+
+// Remove removes the transaction with the given ID, reporting whether it was pending
+func (m *Mempool) Remove(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.entries[id]
+	if !exists {
+		return false
+	}
+	m.removeLocked(entry, TxRemoved)
+	return true
+}
+
+// RemoveWithDescendants removes the transaction with the given ID together
+// with the sender's higher nonces, which can no longer be mined without it.
+// It returns how many transactions were removed.
+func (m *Mempool) RemoveWithDescendants(id string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.entries[id]
+	if !exists {
+		return 0
+	}
+	before := len(m.entries)
+	m.removeWithDescendantsLocked(entry, TxRemoved)
+	return before - len(m.entries)
+}
+
+// Get returns the pending transaction with the given ID
+func (m *Mempool) Get(id string) (Transaction, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, exists := m.entries[id]
+	if !exists {
+		return nil, false
 	}
+	return entry.tx, true
+}
+
+// Has reports whether a transaction with the given ID is pending
+func (m *Mempool) Has(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, exists := m.entries[id]
+	return exists
+}
+
+// Len returns the number of pending transactions
+func (m *Mempool) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+// Bytes returns the serialized size of all pending transactions
+func (m *Mempool) Bytes() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.totalBytes
 }
 
 func (m *Mempool) Clear() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.transactions = []Transaction{}
+	for _, entry := range m.entries {
+		m.removeLocked(entry, TxRemoved)
+	}
 }
 
+// GetTransactions returns every pending transaction in block-building order
 func (m *Mempool) GetTransactions() []Transaction {
+	return m.SelectTransactions(0, 0)
+}
+
+// SelectTransactions picks transactions for a block by descending fee rate,
+// never taking a sender's nonce before its lower nonces. Zero limits are
+// treated as unlimited.
+func (m *Mempool) SelectTransactions(maxCount, maxBytes int) []Transaction {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var selected []Transaction
+	for _, entry := range m.selectLocked(maxCount, maxBytes) {
+		selected = append(selected, entry.tx)
+	}
+	return selected
+}
+
+func (m *Mempool) selectLocked(maxCount, maxBytes int) []*mempoolEntry {
+	// Each sender's pending transactions in nonce order
+	queues := make(map[string][]*mempoolEntry, len(m.bySender))
+	for sender, byNonce := range m.bySender {
+		queue := make([]*mempoolEntry, 0, len(byNonce))
+		for _, entry := range byNonce {
+			queue = append(queue, entry)
+		}
+		sort.Slice(queue, func(i, j int) bool { return queue[i].tx.GetNonce() < queue[j].tx.GetNonce() })
+		queues[sender] = queue
+	}
+
+	candidates := &priorityHeap{}
+	for _, queue := range queues {
+		heap.Push(candidates, queue[0])
+	}
+
+	var selected []*mempoolEntry
+	size := 0
+	for candidates.Len() > 0 {
+		if maxCount > 0 && len(selected) >= maxCount {
+			break
+		}
+		entry := heap.Pop(candidates).(*mempoolEntry)
+		if maxBytes > 0 && size+entry.size > maxBytes {
+			continue // later nonces of this sender cannot be included either
+		}
+		selected = append(selected, entry)
+		size += entry.size
+
+		sender := entry.tx.GetSender()
+		queue := queues[sender][1:]
+		queues[sender] = queue
+		if len(queue) > 0 && queue[0].tx.GetNonce() == entry.tx.GetNonce()+1 {
+			heap.Push(candidates, queue[0])
+		}
+	}
+	return selected
+}
+
+// Save writes every pending transaction to MempoolFile in dataDir in
+// block-building order, so each sender's nonces are saved in sequence. The
+// file is replaced atomically by SaveData, and concurrent saves, such as the
+// periodic one and the one at shutdown, run one at a time.
+func (m *Mempool) Save(dataDir string) error {
+	m.saveMutex.Lock()
+	defer m.saveMutex.Unlock()
+
+	m.mu.Lock()
+	entries := m.selectLocked(0, 0)
+	m.mu.Unlock()
+
+	saved := make([]SavedTransaction, 0, len(entries))
+	for _, entry := range entries {
+		data, err := json.Marshal(entry.tx)
+		if err != nil {
+			return fmt.Errorf("failed to encode transaction %s: %v", entry.id, err)
+		}
+		saved = append(saved, SavedTransaction{Tx: data, Added: entry.added})
+	}
+	return SaveData(saved, MempoolFile, dataDir)
+}
+
+// LoadSavedMempool reads the transactions written by Save. A missing file
+// yields no transactions.
+func LoadSavedMempool(dataDir string) ([]SavedTransaction, error) {
+	if _, err := os.Stat(filepath.Join(dataDir, MempoolFile)); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	var saved []SavedTransaction
+	if err := LoadData(MempoolFile, dataDir, &saved); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+// Expire removes transactions older than the TTL and returns how many were removed
+func (m *Mempool) Expire() int {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.expireLocked(time.Now())
+}
+
+func (m *Mempool) expireLocked(now time.Time) int {
+	if m.config.TTL <= 0 {
+		return 0
+	}
+	expired := 0
+	for _, entry := range m.entries {
+		if now.Sub(entry.added) > m.config.TTL {
+			m.removeLocked(entry, TxEvicted)
+			expired++
+		}
+	}
+	return expired
+}
+
+func (m *Mempool) overLimitsLocked() bool {
+	if len(m.entries) == 0 {
+		return false
+	}
+	return (m.config.MaxTxs > 0 && len(m.entries) > m.config.MaxTxs) ||
+		(m.config.MaxBytes > 0 && m.totalBytes > m.config.MaxBytes)
+}
+
+func (m *Mempool) insertLocked(entry *mempoolEntry) {
+	m.entries[entry.id] = entry
+	sender := entry.tx.GetSender()
+	if m.bySender[sender] == nil {
+		m.bySender[sender] = make(map[uint64]*mempoolEntry)
+	}
+	m.bySender[sender][entry.tx.GetNonce()] = entry
+	heap.Push(&m.evictQueue, entry)
+	m.totalBytes += entry.size
+}
+
+func (m *Mempool) removeLocked(entry *mempoolEntry, reason MempoolEventType) {
+	m.detachLocked(entry)
+	m.notify(reason, entry.tx)
+}
+
+// detachLocked removes entry from the indexes without notifying subscribers
+func (m *Mempool) detachLocked(entry *mempoolEntry) {
+	delete(m.entries, entry.id)
+	sender := entry.tx.GetSender()
+	delete(m.bySender[sender], entry.tx.GetNonce())
+	if len(m.bySender[sender]) == 0 {
+		delete(m.bySender, sender)
+	}
+	heap.Remove(&m.evictQueue, entry.index)
+	m.totalBytes -= entry.size
+}
+
+// replacementFee returns the lowest fee that may replace a pending transaction paying fee
+func replacementFee(fee uint64) uint64 {
+	bump := fee * MinReplacementFeeBump / 100
+	if bump == 0 {
+		bump = 1
+	}
+	return fee + bump
+}
 
-	return append([]Transaction{}, m.transactions...)
+// removeWithDescendantsLocked evicts entry together with the sender's higher
+// nonces, which could no longer be mined without it
+func (m *Mempool) removeWithDescendantsLocked(entry *mempoolEntry, reason MempoolEventType) {
+	for _, victim := range m.withDescendantsLocked(entry) {
+		m.removeLocked(victim, reason)
+	}
+}
+
+// withDescendantsLocked returns the sender's entries with higher nonces
+// followed by entry itself
+func (m *Mempool) withDescendantsLocked(entry *mempoolEntry) []*mempoolEntry {
+	sender, nonce := entry.tx.GetSender(), entry.tx.GetNonce()
+	var entries []*mempoolEntry
+	for _, other := range m.bySender[sender] {
+		if other.tx.GetNonce() > nonce {
+			entries = append(entries, other)
+		}
+	}
+	return append(entries, entry)
+}
+
+// evictionHeap orders entries by ascending fee rate, newest first on ties
+type evictionHeap []*mempoolEntry
+
+func (h evictionHeap) Len() int { return len(h) }
+func (h evictionHeap) Less(i, j int) bool {
+	if h[i].feeRate != h[j].feeRate {
+		return h[i].feeRate < h[j].feeRate
+	}
+	return h[i].added.After(h[j].added)
+}
+func (h evictionHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *evictionHeap) Push(x interface{}) {
+	entry := x.(*mempoolEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+func (h *evictionHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+// priorityHeap orders entries by descending fee rate, oldest first on ties
+type priorityHeap []*mempoolEntry
+
+func (h priorityHeap) Len() int { return len(h) }
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].feeRate != h[j].feeRate {
+		return h[i].feeRate > h[j].feeRate
+	}
+	return h[i].added.Before(h[j].added)
+}
+func (h priorityHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *priorityHeap) Push(x interface{}) { *h = append(*h, x.(*mempoolEntry)) }
+func (h *priorityHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
 }
@@ -0,0 +1,80 @@
+package storage
+
+// MempoolEventType identifies what happened to a pending transaction
+type MempoolEventType int
+
+const (
+	// TxAdded is sent when a transaction enters the mempool
+	TxAdded MempoolEventType = iota
+	// TxRemoved is sent when a transaction is removed because it was mined,
+	// conflicts with the chain or was removed explicitly
+	TxRemoved
+	// TxEvicted is sent when a transaction is pushed out by the size limits,
+	// replaced by a higher fee or expires
+	TxEvicted
+)
+
+func (t MempoolEventType) String() string {
+	switch t {
+	case TxAdded:
+		return "added"
+	case TxRemoved:
+		return "removed"
+	case TxEvicted:
+		return "evicted"
+	default:
+		return "unknown"
+	}
+}
+
+// MempoolEvent notifies subscribers of changes to the mempool
+type MempoolEvent struct {
+	Type MempoolEventType
+	Tx   Transaction
+}
+
+// Subscribe returns a channel that receives mempool events. A subscriber
+// whose buffer fills up is dropped and its channel closed, so consumers must
+// keep up or resubscribe.
+func (m *Mempool) Subscribe(buffer int) <-chan MempoolEvent {
+	m.subsMutex.Lock()
+	defer m.subsMutex.Unlock()
+
+	ch := make(chan MempoolEvent, buffer)
+	m.subscribers = append(m.subscribers, ch)
+	return ch
+}
+
+// Unsubscribe stops delivery to a channel returned by Subscribe and closes it
+func (m *Mempool) Unsubscribe(sub <-chan MempoolEvent) {
+	m.subsMutex.Lock()
+	defer m.subsMutex.Unlock()
+
+	for i, ch := range m.subscribers {
+		if ch == sub {
+			m.subscribers = append(m.subscribers[:i], m.subscribers[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+func (m *Mempool) notify(eventType MempoolEventType, tx Transaction) {
+	m.subsMutex.Lock()
+	defer m.subsMutex.Unlock()
+
+	event := MempoolEvent{Type: eventType, Tx: tx}
+	kept := m.subscribers[:0]
+	for _, ch := range m.subscribers {
+		select {
+		case ch <- event:
+			kept = append(kept, ch)
+		default:
+			close(ch)
+		}
+	}
+	for i := len(kept); i < len(m.subscribers); i++ {
+		m.subscribers[i] = nil
+	}
+	m.subscribers = kept
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTx is a Transaction with a fixed serialized size unless padded, so its
+// fee rate is proportional to its fee
+type testTx struct {
+	sender string
+	nonce  uint64
+	fee    uint64
+	pad    int // extra serialized bytes
+}
+
+func (tx testTx) Serialize() string {
+	return fmt.Sprintf("%-40s%020d%020d", tx.sender, tx.nonce, tx.fee) + strings.Repeat("x", tx.pad)
+}
+func (tx testTx) ID() string        { return fmt.Sprintf("%s/%d/%d", tx.sender, tx.nonce, tx.fee) }
+func (tx testTx) GetSender() string { return tx.sender }
+func (tx testTx) GetNonce() uint64  { return tx.nonce }
+func (tx testTx) GetFee() uint64    { return tx.fee }
+
+func mustAdd(t *testing.T, m *Mempool, tx testTx) {
+	t.Helper()
+	if err := m.AddTransaction(tx); err != nil {
+		t.Fatalf("AddTransaction(%s): %v", tx.ID(), err)
+	}
+}
+
+func ids(txs []Transaction) []string {
+	var ids []string
+	for _, tx := range txs {
+		ids = append(ids, tx.ID())
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSelectTransactionsByFeeRate(t *testing.T) {
+	m := NewMempool()
+	low := testTx{sender: "alice", nonce: 0, fee: 1}
+	mid := testTx{sender: "bob", nonce: 0, fee: 5}
+	high := testTx{sender: "carol", nonce: 0, fee: 9}
+	for _, tx := range []testTx{low, high, mid} {
+		mustAdd(t, m, tx)
+	}
+
+	got := ids(m.SelectTransactions(0, 0))
+	want := []string{high.ID(), mid.ID(), low.ID()}
+	if !equalIDs(got, want) {
+		t.Errorf("SelectTransactions = %v, want %v", got, want)
+	}
+
+	got = ids(m.SelectTransactions(2, 0))
+	if !equalIDs(got, want[:2]) {
+		t.Errorf("SelectTransactions(2, 0) = %v, want %v", got, want[:2])
+	}
+}
+
+func TestSelectTransactionsKeepsNonceOrder(t *testing.T) {
+	m := NewMempool()
+	// alice's second transaction pays the most but cannot precede her first
+	first := testTx{sender: "alice", nonce: 0, fee: 1}
+	second := testTx{sender: "alice", nonce: 1, fee: 9}
+	other := testTx{sender: "bob", nonce: 0, fee: 5}
+	for _, tx := range []testTx{second, other, first} {
+		mustAdd(t, m, tx)
+	}
+
+	got := ids(m.SelectTransactions(0, 0))
+	want := []string{other.ID(), first.ID(), second.ID()}
+	if !equalIDs(got, want) {
+		t.Errorf("SelectTransactions = %v, want %v", got, want)
+	}
+}
+
+func TestEvictionDropsLowestFeeRate(t *testing.T) {
+	m := NewMempoolWithConfig(MempoolConfig{MaxTxs: 2})
+	low := testTx{sender: "alice", nonce: 0, fee: 1}
+	mid := testTx{sender: "bob", nonce: 0, fee: 5}
+	high := testTx{sender: "carol", nonce: 0, fee: 9}
+	mustAdd(t, m, low)
+	mustAdd(t, m, mid)
+	mustAdd(t, m, high)
+
+	if m.Len() != 2 {
+		t.Fatalf("Len = %d, want 2", m.Len())
+	}
+	if m.Has(low.ID()) {
+		t.Errorf("lowest fee-rate transaction was not evicted")
+	}
+	if !m.Has(mid.ID()) || !m.Has(high.ID()) {
+		t.Errorf("higher fee-rate transactions were evicted")
+	}
+}
+
+func TestEvictionRejectsLowFeeRateWhenFull(t *testing.T) {
+	m := NewMempoolWithConfig(MempoolConfig{MaxTxs: 2})
+	mustAdd(t, m, testTx{sender: "alice", nonce: 0, fee: 5})
+	mustAdd(t, m, testTx{sender: "bob", nonce: 0, fee: 9})
+
+	err := m.AddTransaction(testTx{sender: "carol", nonce: 0, fee: 1})
+	if code, _ := RejectCodeOf(err); code != RejectMempoolFull {
+		t.Fatalf("AddTransaction = %v, want a %s reject", err, RejectMempoolFull)
+	}
+	if m.Len() != 2 {
+		t.Errorf("Len = %d, want 2", m.Len())
+	}
+}
+
+func TestEvictionRemovesDescendants(t *testing.T) {
+	m := NewMempoolWithConfig(MempoolConfig{MaxTxs: 3})
+	parent := testTx{sender: "alice", nonce: 0, fee: 1}
+	child := testTx{sender: "alice", nonce: 1, fee: 8}
+	mustAdd(t, m, parent)
+	mustAdd(t, m, child)
+	mustAdd(t, m, testTx{sender: "bob", nonce: 0, fee: 5})
+	mustAdd(t, m, testTx{sender: "carol", nonce: 0, fee: 6})
+
+	if m.Has(parent.ID()) || m.Has(child.ID()) {
+		t.Errorf("evicting %s left its descendant behind", parent.ID())
+	}
+	if m.Len() != 2 {
+		t.Errorf("Len = %d, want 2", m.Len())
+	}
+}
+
+func TestEvictionByBytes(t *testing.T) {
+	size := len(testTx{}.Serialize())
+	m := NewMempoolWithConfig(MempoolConfig{MaxBytes: 2 * size})
+	low := testTx{sender: "alice", nonce: 0, fee: 1}
+	mustAdd(t, m, low)
+	mustAdd(t, m, testTx{sender: "bob", nonce: 0, fee: 5})
+	mustAdd(t, m, testTx{sender: "carol", nonce: 0, fee: 9})
+
+	if m.Bytes() > 2*size {
+		t.Errorf("Bytes = %d, over the limit of %d", m.Bytes(), 2*size)
+	}
+	if m.Has(low.ID()) {
+		t.Errorf("lowest fee-rate transaction was not evicted")
+	}
+}
+
+func TestReplaceByFee(t *testing.T) {
+	m := NewMempool()
+	original := testTx{sender: "alice", nonce: 0, fee: 100}
+	mustAdd(t, m, original)
+
+	tooLow := testTx{sender: "alice", nonce: 0, fee: replacementFee(100) - 1}
+	if code, _ := RejectCodeOf(m.AddTransaction(tooLow)); code != RejectInsufficient {
+		t.Fatalf("replacement below the fee bump was not rejected as %s", RejectInsufficient)
+	}
+	if !m.Has(original.ID()) {
+		t.Fatalf("rejected replacement removed the original")
+	}
+
+	replacement := testTx{sender: "alice", nonce: 0, fee: replacementFee(100)}
+	mustAdd(t, m, replacement)
+	if m.Has(original.ID()) || !m.Has(replacement.ID()) || m.Len() != 1 {
+		t.Errorf("replacement did not take the original's place")
+	}
+}
+
+func TestReplaceByFeeKeepsOriginalWhenReplacementDoesNotFit(t *testing.T) {
+	size := len(testTx{}.Serialize())
+	m := NewMempoolWithConfig(MempoolConfig{MaxBytes: 2*size + 10})
+	original := testTx{sender: "alice", nonce: 0, fee: 100}
+	other := testTx{sender: "bob", nonce: 0, fee: 1000}
+	mustAdd(t, m, original)
+	mustAdd(t, m, other)
+
+	events := m.Subscribe(8)
+	// Pays enough to replace, but its larger size pushes the pool over the
+	// limit and gives it the lowest fee rate
+	replacement := testTx{sender: "alice", nonce: 0, fee: replacementFee(100), pad: 20}
+	err := m.AddTransaction(replacement)
+	if code, _ := RejectCodeOf(err); code != RejectMempoolFull {
+		t.Fatalf("AddTransaction = %v, want a %s reject", err, RejectMempoolFull)
+	}
+	if !m.Has(original.ID()) || !m.Has(other.ID()) || m.Len() != 2 {
+		t.Errorf("refused replacement changed the pool")
+	}
+	if m.Bytes() != 2*size {
+		t.Errorf("Bytes = %d, want %d", m.Bytes(), 2*size)
+	}
+	select {
+	case event := <-events:
+		t.Errorf("refused replacement sent a %s event for %s", event.Type, event.Tx.ID())
+	default:
+	}
+}
+
+func TestExpiredTransactionRejected(t *testing.T) {
+	m := NewMempoolWithConfig(MempoolConfig{TTL: time.Hour})
+	err := m.RestoreTransaction(testTx{sender: "alice", nonce: 0, fee: 1}, time.Now().Add(-2*time.Hour))
+	if code, _ := RejectCodeOf(err); code != RejectExpired {
+		t.Fatalf("RestoreTransaction = %v, want a %s reject", err, RejectExpired)
+	}
+	if m.Len() != 0 {
+		t.Errorf("Len = %d, want 0", m.Len())
+	}
+}
+
+func TestExpireRemovesOldTransactions(t *testing.T) {
+	m := NewMempoolWithConfig(MempoolConfig{TTL: time.Hour})
+	old := testTx{sender: "alice", nonce: 0, fee: 1}
+	fresh := testTx{sender: "bob", nonce: 0, fee: 1}
+	if err := m.RestoreTransaction(old, time.Now().Add(-time.Hour+time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	mustAdd(t, m, fresh)
+
+	events := m.Subscribe(8)
+	// Age the old entry past the TTL without sleeping
+	m.mu.Lock()
+	m.entries[old.ID()].added = time.Now().Add(-2 * time.Hour)
+	m.mu.Unlock()
+
+	if n := m.Expire(); n != 1 {
+		t.Errorf("Expire = %d, want 1", n)
+	}
+	if m.Has(old.ID()) || !m.Has(fresh.ID()) {
+		t.Errorf("Expire removed the wrong transactions")
+	}
+	if event := <-events; event.Type != TxEvicted || event.Tx.ID() != old.ID() {
+		t.Errorf("got a %s event for %s, want evicted for %s", event.Type, event.Tx.ID(), old.ID())
+	}
+}
+
+func TestRejectCodeOf(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", Reject(RejectNonce, "nonce %d", 3))
+	if code, ok := RejectCodeOf(err); !ok || code != RejectNonce {
+		t.Errorf("RejectCodeOf = %q, %v, want %q, true", code, ok, RejectNonce)
+	}
+	if _, ok := RejectCodeOf(errors.New("plain")); ok {
+		t.Errorf("RejectCodeOf reported a code for a plain error")
+	}
+}
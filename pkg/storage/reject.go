@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RejectCode classifies why a transaction was not admitted to the mempool
+type RejectCode string
+
+const (
+	RejectMalformed    RejectCode = "malformed"        // missing or badly encoded fields
+	RejectInvalid      RejectCode = "invalid"          // bad signature
+	RejectDuplicate    RejectCode = "duplicate"        // already pending
+	RejectNonce        RejectCode = "nonce"            // nonce already used or leaves a gap
+	RejectConflict     RejectCode = "conflict"         // disagrees with a registered result
+	RejectBalance      RejectCode = "balance"          // sender cannot pay the fee
+	RejectInsufficient RejectCode = "insufficient-fee" // replacement does not pay enough
+	RejectMempoolFull  RejectCode = "mempool-full"     // fee too low to displace pending transactions
+	RejectExpired      RejectCode = "expired"          // waited longer than the mempool TTL
+)
+
+// RejectError reports a transaction that was refused, in a form that can be
+// passed on to the peer that sent it
+type RejectError struct {
+	Code   RejectCode
+	Reason string
+}
+
+func (e *RejectError) Error() string {
+	return fmt.Sprintf("transaction rejected (%s): %s", e.Code, e.Reason)
+}
+
+// Reject returns a RejectError with a formatted reason
+func Reject(code RejectCode, format string, args ...interface{}) *RejectError {
+	return &RejectError{Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// RejectCodeOf returns the reject code carried by err, if any
+func RejectCodeOf(err error) (RejectCode, bool) {
+	var reject *RejectError
+	if errors.As(err, &reject) {
+		return reject.Code, true
+	}
+	return "", false
+}
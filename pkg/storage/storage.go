@@ -7,13 +7,17 @@ import (
 	"path/filepath"
 )
 
-// SaveData saves any data structure as a JSON file
+// SaveData saves any data structure as a JSON file. The data is written to a
+// temporary file, synced and renamed into place, and the directory is synced
+// so the rename survives a crash. A crash never leaves a partially written
+// file behind.
 func SaveData(data interface{}, fileName string, dataDir string) error {
 	filePath := filepath.Join(dataDir, fileName)
-	file, err := os.Create(filePath)
+	file, err := os.CreateTemp(dataDir, fileName+".tmp*")
 	if err != nil {
 		return fmt.Errorf("failed to create file: %v", err)
 	}
+	defer os.Remove(file.Name())
 	defer file.Close()
 
 	encoder := json.NewEncoder(file)
@@ -21,7 +25,28 @@ func SaveData(data interface{}, fileName string, dataDir string) error {
 	if err != nil {
 		return fmt.Errorf("failed to encode data to JSON: %v", err)
 	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close file: %v", err)
+	}
+	if err := os.Rename(file.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to rename file: %v", err)
+	}
+	return syncDir(dataDir)
+}
 
+// syncDir flushes a directory so that files renamed into it stay renamed
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory: %v", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory: %v", err)
+	}
 	return nil
 }
 
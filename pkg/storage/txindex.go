@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const txIndexFile = "txindex.db"
+
+var (
+	txIDBucket   = []byte("txid") // transaction ID -> location
+	txMetaBucket = []byte("meta")
+	indexTipKey  = []byte("tip") // height of the last indexed block
+
+	// ErrTxNotFound is returned when a transaction ID is not indexed
+	ErrTxNotFound = errors.New("transaction not found")
+)
+
+// TxLocation identifies where a transaction is stored in the chain
+type TxLocation struct {
+	Height   uint64
+	Position uint32 // index within the block's transactions
+	TxID     string
+}
+
+// IndexedTx is a transaction as seen by the index: its ID and the
+// field values it should be found under
+type IndexedTx struct {
+	ID     string
+	Fields map[string]string // index name -> value
+}
+
+// TxIndex maintains secondary indexes over transactions in a bbolt database.
+// Each index maps a field value to the locations of matching transactions,
+// ordered by height and position.
+type TxIndex struct {
+	db *bolt.DB
+}
+
+// OpenTxIndex opens or creates the transaction index in dataDir
+func OpenTxIndex(dataDir string) (*TxIndex, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %v", err)
+	}
+	db, err := openDB(filepath.Join(dataDir, txIndexFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open transaction index: %w", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{txIDBucket, txMetaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize transaction index: %v", err)
+	}
+	return &TxIndex{db: db}, nil
+}
+
+// Close releases the index database
+func (ti *TxIndex) Close() error {
+	return ti.db.Close()
+}
+
+// Tip returns the height of the last indexed block
+func (ti *TxIndex) Tip() (uint64, bool) {
+	var height uint64
+	var found bool
+	ti.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(txMetaBucket).Get(indexTipKey); value != nil {
+			height, found = binary.BigEndian.Uint64(value), true
+		}
+		return nil
+	})
+	return height, found
+}
+
+// IndexBlock adds the transactions of the block at height to every index
+func (ti *TxIndex) IndexBlock(height uint64, txs []IndexedTx) error {
+	return ti.db.Update(func(tx *bolt.Tx) error {
+		for pos, itx := range txs {
+			loc := encodeTxLocation(height, uint32(pos))
+			if err := tx.Bucket(txIDBucket).Put([]byte(itx.ID), loc); err != nil {
+				return err
+			}
+			for field, value := range itx.Fields {
+				bucket, err := tx.CreateBucketIfNotExists([]byte(field))
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put(indexKey(value, height, uint32(pos)), []byte(itx.ID)); err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Bucket(txMetaBucket).Put(indexTipKey, heightKey(height))
+	})
+}
+
+// RemoveBlock removes the transactions of a disconnected block at height
+func (ti *TxIndex) RemoveBlock(height uint64, txs []IndexedTx) error {
+	return ti.db.Update(func(tx *bolt.Tx) error {
+		for pos, itx := range txs {
+			ids := tx.Bucket(txIDBucket)
+			if bytes.Equal(ids.Get([]byte(itx.ID)), encodeTxLocation(height, uint32(pos))) {
+				if err := ids.Delete([]byte(itx.ID)); err != nil {
+					return err
+				}
+			}
+			for field, value := range itx.Fields {
+				if bucket := tx.Bucket([]byte(field)); bucket != nil {
+					if err := bucket.Delete(indexKey(value, height, uint32(pos))); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if height == 0 {
+			return tx.Bucket(txMetaBucket).Delete(indexTipKey)
+		}
+		return tx.Bucket(txMetaBucket).Put(indexTipKey, heightKey(height-1))
+	})
+}
+
+// TruncateAbove removes every entry for blocks above height, for when those
+// blocks were discarded without being disconnected one by one. Entries at or
+// below height, including those of pruned blocks, are kept.
+func (ti *TxIndex) TruncateAbove(height uint64) error {
+	return ti.db.Update(func(tx *bolt.Tx) error {
+		err := tx.ForEach(func(name []byte, bucket *bolt.Bucket) error {
+			if bytes.Equal(name, txMetaBucket) {
+				return nil
+			}
+			isIDs := bytes.Equal(name, txIDBucket)
+			var stale [][]byte
+			err := bucket.ForEach(func(k, v []byte) error {
+				loc := v // txid -> location
+				if !isIDs {
+					loc = k[len(k)-12:] // value, 0, location -> txid
+				}
+				if binary.BigEndian.Uint64(loc[0:8]) > height {
+					stale = append(stale, append([]byte(nil), k...))
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for _, k := range stale {
+				if err := bucket.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(txMetaBucket).Put(indexTipKey, heightKey(height))
+	})
+}
+
+// Lookup returns the location of a transaction by ID
+func (ti *TxIndex) Lookup(txID string) (TxLocation, error) {
+	var loc TxLocation
+	err := ti.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(txIDBucket).Get([]byte(txID))
+		if value == nil {
+			return ErrTxNotFound
+		}
+		loc = decodeTxLocation(value, txID)
+		return nil
+	})
+	return loc, err
+}
+
+// Query returns up to limit transactions whose field equals value, starting
+// after cursor. The returned cursor is empty once there are no more results.
+func (ti *TxIndex) Query(field, value, cursor string, limit int) ([]TxLocation, string, error) {
+	prefix := append([]byte(value), 0)
+	start := prefix
+	if cursor != "" {
+		decoded, err := hex.DecodeString(cursor)
+		if err != nil || !bytes.HasPrefix(decoded, prefix) {
+			return nil, "", fmt.Errorf("invalid cursor")
+		}
+		start = decoded
+	}
+
+	var results []TxLocation
+	var next string
+	err := ti.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(field))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		k, v := c.Seek(start)
+		if cursor != "" && bytes.Equal(k, start) {
+			k, v = c.Next()
+		}
+		var lastKey []byte
+		for ; k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if len(results) == limit {
+				next = hex.EncodeToString(lastKey)
+				break
+			}
+			results = append(results, decodeTxLocation(k[len(prefix):], string(v)))
+			lastKey = append(lastKey[:0], k...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return results, next, nil
+}
+
+func indexKey(value string, height uint64, pos uint32) []byte {
+	key := make([]byte, len(value)+1+12)
+	copy(key, value)
+	copy(key[len(value)+1:], encodeTxLocation(height, pos))
+	return key
+}
+
+func encodeTxLocation(height uint64, pos uint32) []byte {
+	loc := make([]byte, 12)
+	binary.BigEndian.PutUint64(loc[0:8], height)
+	binary.BigEndian.PutUint32(loc[8:12], pos)
+	return loc
+}
+
+func decodeTxLocation(value []byte, txID string) TxLocation {
+	return TxLocation{
+		Height:   binary.BigEndian.Uint64(value[0:8]),
+		Position: binary.BigEndian.Uint32(value[8:12]),
+		TxID:     txID,
+	}
+}